@@ -0,0 +1,11 @@
+package main
+
+import (
+	"github.com/catnekaise/ghrawel-tokenprovider-lambda-go/internal"
+	"os"
+)
+
+func main() {
+
+	os.Exit(internal.TokenCli(os.Args[1:], os.Stdout, os.Stderr))
+}
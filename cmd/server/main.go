@@ -0,0 +1,16 @@
+package main
+
+import (
+	"flag"
+	"github.com/catnekaise/ghrawel-tokenprovider-lambda-go/internal"
+	"os"
+)
+
+func main() {
+
+	addr := flag.String("addr", "127.0.0.1:8080", "address to listen on")
+	config := flag.String("config", os.Getenv("TOKEN_PROVIDERS_FILE"), "providers file describing the apps, permissions and target rules of each provider")
+	flag.Parse()
+
+	internal.StartServer(*addr, *config)
+}
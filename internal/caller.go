@@ -0,0 +1,69 @@
+package internal
+
+import (
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/catnekaise/ghrawel-tokenprovider-lambda-go/pkg/api"
+)
+
+func restApiCaller(req api.Input) api.Caller {
+
+	identity := req.RequestContext.Identity
+
+	return api.Caller{
+		RequestId:                     req.RequestContext.RequestID,
+		UserArn:                       identity.UserArn,
+		User:                          identity.User,
+		AccountId:                     identity.AccountID,
+		UserAgent:                     identity.UserAgent,
+		SourceIp:                      identity.SourceIP,
+		Path:                          req.RequestContext.Path,
+		CognitoIdentityPoolId:         identity.CognitoIdentityPoolID,
+		CognitoIdentityId:             identity.CognitoIdentityID,
+		CognitoAuthenticationProvider: identity.CognitoAuthenticationProvider,
+		CognitoAuthenticationType:     identity.CognitoAuthenticationType,
+	}
+}
+
+func httpApiCaller(req events.APIGatewayV2HTTPRequest) api.Caller {
+
+	caller := api.Caller{
+		RequestId: req.RequestContext.RequestID,
+		UserAgent: req.RequestContext.HTTP.UserAgent,
+		SourceIp:  req.RequestContext.HTTP.SourceIP,
+		Path:      req.RequestContext.HTTP.Path,
+	}
+
+	if req.RequestContext.Authorizer != nil && req.RequestContext.Authorizer.IAM != nil {
+
+		iam := req.RequestContext.Authorizer.IAM
+
+		caller.UserArn = iam.UserARN
+		caller.User = iam.UserID
+		caller.AccountId = iam.AccountID
+		caller.CognitoIdentityPoolId = iam.CognitoIdentity.IdentityPoolID
+		caller.CognitoIdentityId = iam.CognitoIdentity.IdentityID
+	}
+
+	return caller
+}
+
+func functionUrlCaller(req events.LambdaFunctionURLRequest) api.Caller {
+
+	caller := api.Caller{
+		RequestId: req.RequestContext.RequestID,
+		UserAgent: req.RequestContext.HTTP.UserAgent,
+		SourceIp:  req.RequestContext.HTTP.SourceIP,
+		Path:      req.RequestContext.HTTP.Path,
+	}
+
+	if req.RequestContext.Authorizer != nil && req.RequestContext.Authorizer.IAM != nil {
+
+		iam := req.RequestContext.Authorizer.IAM
+
+		caller.UserArn = iam.UserARN
+		caller.User = iam.UserID
+		caller.AccountId = iam.AccountID
+	}
+
+	return caller
+}
@@ -0,0 +1,244 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/catnekaise/ghrawel-tokenprovider-lambda-go/pkg/api"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+const cliTimeout = 30 * time.Second
+
+// TokenCli builds an api.Input from the command line arguments and runs it through the same validation and
+// token path as the function. Private keys are read according to SECRETS_STORAGE and SECRETS_PREFIX. It
+// returns the exit code of the command.
+func TokenCli(args []string, stdout io.Writer, stderr io.Writer) int {
+
+	flags := flag.NewFlagSet("ghrawel-token", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+
+	owner := flags.String("owner", "", "owner of the repositories")
+	repo := flags.String("repo", "", "comma separated repository names or id:<ID>, omitted to select all repositories of the owner")
+	appId := flags.Int64("app-id", 0, "id of the GitHub App")
+	appName := flags.String("app-name", "default", "name of the GitHub App the private keys are stored under")
+	baseUrl := flags.String("base-url", "", "API URL of the GitHub Enterprise Server the app is registered on")
+	permissions := flags.String("permissions", "", "comma separated permissions, such as contents=read,issues=write")
+	endpointType := flags.String("endpoint-type", api.EndpointTypeDefault, "endpoint type")
+	selectionMode := flags.String("selection-mode", api.RepositorySelectionModeAtLeastOne, "repository selection mode")
+	providerName := flags.String("provider", "ghrawel-token", "name of the token provider in logs")
+	dryRun := flags.Bool("dry-run", false, "validate owner and repositories without creating a token")
+	printJson := flags.Bool("json", false, "print the result as JSON")
+	debug := flags.Bool("debug", false, "log at debug level")
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	minLevel := slog.LevelWarn
+
+	if *debug {
+		minLevel = slog.LevelDebug
+	}
+
+	slog.SetDefault(slog.New(logger{minLevel: minLevel, next: slog.NewJSONHandler(stderr, &slog.HandlerOptions{Level: minLevel})}))
+
+	perms, err := parsePermissions(*permissions)
+
+	if err != nil {
+		fmt.Fprintf(stderr, "invalid -permissions: %s\n", err.Error())
+		return 2
+	}
+
+	req := api.Input{
+		TokenRequest: api.TokenRequest{Owner: *owner},
+		TokenContext: api.TokenContext{
+			ProviderName: *providerName,
+			Permissions:  perms,
+			App:          api.App{Id: *appId, Name: *appName},
+			Endpoint:     api.Endpoint{Type: *endpointType},
+			TargetRule:   api.TargetRule{RepositorySelectionMode: *selectionMode},
+		},
+	}
+
+	if *repo != "" {
+		req.TokenRequest.Repo = repo
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cliTimeout)
+	defer cancel()
+
+	if *dryRun {
+
+		validOwner, repos, err := (&Service{logger: slog.Default()}).validateInput(ctx, req)
+
+		if err != nil {
+			return printCliError(stderr, err)
+		}
+
+		if *printJson {
+			return printCliJson(stdout, stderr, map[string]any{"owner": validOwner, "repositories": repos})
+		}
+
+		fmt.Fprintf(stdout, "owner:        %s\n", validOwner)
+		fmt.Fprintf(stdout, "repositories: %s\n", formatCliRepositories(repos))
+
+		return 0
+	}
+
+	caller := api.Caller{RequestId: newRequestId(), User: os.Getenv("USER"), UserAgent: "ghrawel-token"}
+
+	config, err := loadConfig(os.Getenv)
+
+	if err != nil {
+		fmt.Fprintf(stderr, "invalid configuration: %s\n", strings.ReplaceAll(err.Error(), "\n", "; "))
+		return 2
+	}
+
+	if *baseUrl != "" {
+		config.GitHubApiUrl = *baseUrl
+	}
+
+	service, err := newServiceFromConfig(ctx, config)
+
+	if err != nil {
+		fmt.Fprintf(stderr, "invalid configuration: %s\n", err.Error())
+		return 2
+	}
+
+	result, err := service.serve(ctx, req, caller)
+
+	if err != nil {
+		return printCliError(stderr, err)
+	}
+
+	token, ok := result.(*api.TokenResponse)
+
+	if !ok {
+		fmt.Fprintf(stderr, "unexpected result %T\n", result)
+		return 1
+	}
+
+	if *printJson {
+		return printCliJson(stdout, stderr, token)
+	}
+
+	fmt.Fprintf(stdout, "token:        %s\n", token.Token)
+
+	if token.ExpiresAt != nil {
+		fmt.Fprintf(stdout, "expires:      %s\n", token.ExpiresAt.Format(time.RFC3339))
+	}
+
+	fmt.Fprintf(stdout, "selection:    %s\n", token.RepositorySelection)
+
+	names := make([]string, 0, len(token.Repositories))
+
+	for _, repository := range token.Repositories {
+		names = append(names, repository.FullName)
+	}
+
+	fmt.Fprintf(stdout, "repositories: %s\n", formatCliRepositories(names))
+	fmt.Fprintf(stdout, "permissions:  %s\n", formatCliPermissions(token.Permissions))
+
+	return 0
+}
+
+// parsePermissions reads permissions such as contents=read,issues=write using the JSON names of
+// api.Permissions.
+func parsePermissions(value string) (api.Permissions, error) {
+
+	var permissions api.Permissions
+
+	values := map[string]string{}
+
+	for _, pair := range strings.Split(value, ",") {
+
+		pair = strings.TrimSpace(pair)
+
+		if pair == "" {
+			continue
+		}
+
+		name, access, ok := strings.Cut(pair, "=")
+
+		if !ok || name == "" || access == "" {
+			return permissions, errors.New(fmt.Sprintf("%q is not name=access", pair))
+		}
+
+		values[name] = access
+	}
+
+	data, err := json.Marshal(values)
+
+	if err != nil {
+		return permissions, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(&permissions); err != nil {
+		return permissions, err
+	}
+
+	return permissions, nil
+}
+
+func formatCliPermissions(permissions *api.Permissions) string {
+
+	values := permissionsMap(permissions)
+
+	if len(values) == 0 {
+		return "-"
+	}
+
+	pairs := make([]string, 0, len(values))
+
+	for _, name := range sortedKeys(values) {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", name, values[name]))
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+func formatCliRepositories(repos []string) string {
+
+	if len(repos) == 0 {
+		return "all repositories of owner"
+	}
+
+	return strings.Join(repos, ",")
+}
+
+func printCliJson(stdout io.Writer, stderr io.Writer, value any) int {
+
+	encoder := json.NewEncoder(stdout)
+	encoder.SetIndent("", "  ")
+
+	if err := encoder.Encode(value); err != nil {
+		fmt.Fprintln(stderr, err.Error())
+		return 1
+	}
+
+	return 0
+}
+
+func printCliError(stderr io.Writer, err error) int {
+
+	var e *errorResponse
+
+	if errors.As(err, &e) {
+		fmt.Fprintf(stderr, "%s (%v): %s\n", e.Code, e.statusCode, e.Message)
+	} else {
+		fmt.Fprintln(stderr, err.Error())
+	}
+
+	return 1
+}
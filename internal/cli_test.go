@@ -0,0 +1,84 @@
+package internal
+
+import (
+	"bytes"
+	"github.com/catnekaise/ghrawel-tokenprovider-lambda-go/pkg/api"
+	"github.com/google/go-github/v60/github"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_TokenCli(t *testing.T) {
+
+	defaultLogger := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(defaultLogger) })
+	t.Setenv("GITHUB_APP_PRIVATE_KEY_DEFAULT", createTestPrivateKey(t))
+	t.Setenv("SECRETS_STORAGE", "ENV")
+	t.Setenv("SECRETS_PREFIX", "")
+
+	server := createTestEnterpriseServer(t)
+
+	tests := []struct {
+		name       string
+		args       []string
+		wantCode   int
+		wantStdout string
+		wantStderr string
+	}{
+		{
+			name:       "token",
+			args:       []string{"-owner", "catnekaise", "-repo", "example-repo", "-app-id", "1234", "-base-url", server.URL, "-permissions", "contents=read"},
+			wantStdout: "token:        ghs_example",
+		},
+		{
+			name:       "dry run",
+			args:       []string{"-owner", "catnekaise", "-repo", "example-repo", "-dry-run"},
+			wantStdout: "repositories: example-repo",
+		},
+		{
+			name:       "dry run with invalid repo",
+			args:       []string{"-owner", "catnekaise", "-repo", "example-repo#", "-dry-run"},
+			wantCode:   1,
+			wantStderr: "INVALID_REPOSITORY_SELECTION (400)",
+		},
+		{
+			name:       "unknown permission",
+			args:       []string{"-owner", "catnekaise", "-permissions", "unknown=read"},
+			wantCode:   2,
+			wantStderr: "invalid -permissions",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout := new(bytes.Buffer)
+			stderr := new(bytes.Buffer)
+
+			code := TokenCli(tt.args, stdout, stderr)
+
+			if code != tt.wantCode || !strings.Contains(stdout.String(), tt.wantStdout) || !strings.Contains(stderr.String(), tt.wantStderr) {
+				t.Errorf("TokenCli() got = %v %q %q, want %v %q %q", code, stdout, stderr, tt.wantCode, tt.wantStdout, tt.wantStderr)
+			}
+		})
+	}
+}
+
+func Test_parsePermissions(t *testing.T) {
+
+	got, err := parsePermissions("contents=read, pull_requests=write")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := api.Permissions{Contents: github.String("read"), PullRequests: github.String("write")}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parsePermissions() got = %+v, want %+v", got, want)
+	}
+
+	if _, err := parsePermissions("contents"); err == nil {
+		t.Error("parsePermissions() error = nil, want error")
+	}
+}
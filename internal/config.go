@@ -0,0 +1,173 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/catnekaise/ghrawel-tokenprovider-lambda-go/pkg/keys"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultPrivateKeyCacheTtl = 5 * time.Minute
+
+// Config is the configuration of the function, read from its environment variables once at cold start.
+type Config struct {
+	SecretsStorage string
+	SecretsPrefix  string
+	GitHubApiUrl   string
+	// GitHubAllowedApiUrls are the API URLs apps of token contexts may set besides GitHubApiUrl.
+	GitHubAllowedApiUrls []string
+	PrivateKeyCacheTtl   time.Duration
+	ProvidersFile        string
+	// PermissionPolicyFile and PermissionPolicyParameter locate the optional permission policy.
+	PermissionPolicyFile      string
+	PermissionPolicyParameter string
+	DebugLogging              bool
+}
+
+// loadConfig reads and validates every setting, so that a misconfigured function fails its init phase
+// instead of every request. All invalid settings are reported at once.
+func loadConfig(getenv func(string) string) (Config, error) {
+
+	config := Config{
+		SecretsStorage:            getenv("SECRETS_STORAGE"),
+		SecretsPrefix:             getenv("SECRETS_PREFIX"),
+		GitHubApiUrl:              getenv("GITHUB_API_URL"),
+		PrivateKeyCacheTtl:        defaultPrivateKeyCacheTtl,
+		ProvidersFile:             getenv("TOKEN_PROVIDERS_FILE"),
+		PermissionPolicyFile:      getenv("PERMISSION_POLICY_FILE"),
+		PermissionPolicyParameter: getenv("PERMISSION_POLICY_PARAMETER"),
+	}
+
+	var errs []error
+
+	if !keys.Registered(config.SecretsStorage) {
+		errs = append(errs, errors.New(fmt.Sprintf("SECRETS_STORAGE %q is not one of %s", config.SecretsStorage, strings.Join(keys.Storages(), ", "))))
+	} else if isAwsSecretsStorage(config.SecretsStorage) && !prefixRegex.MatchString(config.SecretsPrefix) {
+		errs = append(errs, errors.New(fmt.Sprintf("SECRETS_PREFIX %q is not a valid path for %s", config.SecretsPrefix, config.SecretsStorage)))
+	}
+
+	if config.GitHubApiUrl != "" && !isHttpUrl(config.GitHubApiUrl) {
+		errs = append(errs, errors.New(fmt.Sprintf("GITHUB_API_URL %q is not an http(s) URL", config.GitHubApiUrl)))
+	}
+
+	if value := getenv("GITHUB_ALLOWED_API_URLS"); value != "" {
+
+		for _, apiUrl := range strings.Split(value, ",") {
+
+			apiUrl = strings.TrimSpace(apiUrl)
+
+			if !isHttpUrl(apiUrl) {
+				errs = append(errs, errors.New(fmt.Sprintf("GITHUB_ALLOWED_API_URLS contains %q, which is not an http(s) URL", apiUrl)))
+			}
+
+			config.GitHubAllowedApiUrls = append(config.GitHubAllowedApiUrls, apiUrl)
+		}
+	}
+
+	if value := getenv("PRIVATE_KEY_CACHE_TTL"); value != "" {
+
+		ttl, err := time.ParseDuration(value)
+
+		if err != nil || ttl < 0 {
+			errs = append(errs, errors.New(fmt.Sprintf("PRIVATE_KEY_CACHE_TTL %q is not a duration such as 5m or 0", value)))
+		}
+
+		config.PrivateKeyCacheTtl = ttl
+	}
+
+	if config.PermissionPolicyFile != "" && config.PermissionPolicyParameter != "" {
+		errs = append(errs, errors.New("PERMISSION_POLICY_FILE and PERMISSION_POLICY_PARAMETER are mutually exclusive"))
+	}
+
+	if value := getenv("DEBUG_LOGGING"); value != "" {
+
+		debugLogging, err := parseBool(value)
+
+		if err != nil {
+			errs = append(errs, errors.New(fmt.Sprintf("DEBUG_LOGGING %q is neither true nor false", value)))
+		}
+
+		config.DebugLogging = debugLogging
+	}
+
+	return config, errors.Join(errs...)
+}
+
+// parseBool accepts the values of strconv.ParseBool as well as yes, no, on and off in any case.
+func parseBool(value string) (bool, error) {
+
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "yes", "on":
+		return true, nil
+	case "no", "off":
+		return false, nil
+	}
+
+	return strconv.ParseBool(strings.TrimSpace(value))
+}
+
+func isHttpUrl(value string) bool {
+
+	u, err := url.Parse(value)
+
+	return err == nil && (u.Scheme == "https" || u.Scheme == "http") && u.Host != ""
+}
+
+func (c Config) level() slog.Level {
+
+	if c.DebugLogging {
+		return slog.LevelDebug
+	}
+
+	return slog.LevelInfo
+}
+
+func (c Config) options(ctx context.Context) (Options, error) {
+
+	opts := Options{
+		SecretsStorage:     c.SecretsStorage,
+		SecretsPrefix:      c.SecretsPrefix,
+		BaseUrl:            c.GitHubApiUrl,
+		AllowedBaseUrls:    c.GitHubAllowedApiUrls,
+		PrivateKeyCacheTtl: c.PrivateKeyCacheTtl,
+	}
+
+	if c.ProvidersFile != "" {
+
+		loaded, err := loadProviders(c.ProvidersFile)
+
+		if err != nil {
+			return opts, err
+		}
+
+		opts.Providers = loaded
+	}
+
+	var err error
+
+	if c.PermissionPolicyFile != "" {
+		opts.PermissionPolicy, err = loadPermissionPolicyFile(c.PermissionPolicyFile)
+	} else if c.PermissionPolicyParameter != "" {
+		opts.PermissionPolicy, err = loadPermissionPolicyParameter(ctx, nil, c.PermissionPolicyParameter)
+	}
+
+	return opts, err
+}
+
+// newServiceFromConfig creates the service of the function, loading the providers file and permission policy
+// if there are any.
+func newServiceFromConfig(ctx context.Context, config Config) (*Service, error) {
+
+	opts, err := config.options(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return NewService(ctx, opts)
+}
@@ -0,0 +1,119 @@
+package internal
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_loadConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    Config
+		wantErr []string
+	}{
+		{
+			name: "defaults",
+			env:  map[string]string{"SECRETS_STORAGE": "PARAMETER_STORE", "SECRETS_PREFIX": "/"},
+			want: Config{SecretsStorage: "PARAMETER_STORE", SecretsPrefix: "/", PrivateKeyCacheTtl: 5 * time.Minute},
+		},
+		{
+			name: "all settings",
+			env: map[string]string{
+				"SECRETS_STORAGE":         "FILE",
+				"SECRETS_PREFIX":          "./keys",
+				"GITHUB_API_URL":          "https://github.example.com",
+				"GITHUB_ALLOWED_API_URLS": "https://ghes.example.com, https://other.example.com/api/v3",
+				"PRIVATE_KEY_CACHE_TTL":   "0",
+				"TOKEN_PROVIDERS_FILE":    "providers.json",
+				"DEBUG_LOGGING":           "true",
+			},
+			want: Config{
+				SecretsStorage:       "FILE",
+				SecretsPrefix:        "./keys",
+				GitHubApiUrl:         "https://github.example.com",
+				GitHubAllowedApiUrls: []string{"https://ghes.example.com", "https://other.example.com/api/v3"},
+				ProvidersFile:        "providers.json",
+				DebugLogging:         true,
+			},
+		},
+		{
+			name: "debug logging spelled differently",
+			env:  map[string]string{"SECRETS_STORAGE": "PARAMETER_STORE", "SECRETS_PREFIX": "/", "DEBUG_LOGGING": "Yes"},
+			want: Config{SecretsStorage: "PARAMETER_STORE", SecretsPrefix: "/", PrivateKeyCacheTtl: 5 * time.Minute, DebugLogging: true},
+		},
+		{
+			name: "debug logging as number",
+			env:  map[string]string{"SECRETS_STORAGE": "PARAMETER_STORE", "SECRETS_PREFIX": "/", "DEBUG_LOGGING": "1"},
+			want: Config{SecretsStorage: "PARAMETER_STORE", SecretsPrefix: "/", PrivateKeyCacheTtl: 5 * time.Minute, DebugLogging: true},
+		},
+		{
+			name: "debug logging disabled in upper case",
+			env:  map[string]string{"SECRETS_STORAGE": "PARAMETER_STORE", "SECRETS_PREFIX": "/", "DEBUG_LOGGING": "FALSE"},
+			want: Config{SecretsStorage: "PARAMETER_STORE", SecretsPrefix: "/", PrivateKeyCacheTtl: 5 * time.Minute},
+		},
+		{
+			name:    "Secrets Storage",
+			env:     map[string]string{"SECRETS_STORAGE": "S3", "SECRETS_PREFIX": "/"},
+			wantErr: []string{"SECRETS_STORAGE"},
+		},
+		{
+			name:    "Secrets Prefix",
+			env:     map[string]string{"SECRETS_STORAGE": "PARAMETER_STORE", "SECRETS_PREFIX": "#/"},
+			wantErr: []string{"SECRETS_PREFIX"},
+		},
+		{
+			name: "every invalid setting reported",
+			env: map[string]string{
+				"SECRETS_STORAGE":             "SECRETS_MANAGER",
+				"SECRETS_PREFIX":              "/",
+				"GITHUB_API_URL":              "github.example.com",
+				"GITHUB_ALLOWED_API_URLS":     "https://ghes.example.com,ghes",
+				"PRIVATE_KEY_CACHE_TTL":       "5",
+				"DEBUG_LOGGING":               "maybe",
+				"PERMISSION_POLICY_FILE":      "policy.json",
+				"PERMISSION_POLICY_PARAMETER": "/policy",
+			},
+			wantErr: []string{"GITHUB_API_URL", "GITHUB_ALLOWED_API_URLS", "PRIVATE_KEY_CACHE_TTL", "DEBUG_LOGGING", "PERMISSION_POLICY_FILE"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := loadConfig(func(key string) string { return tt.env[key] })
+
+			if len(tt.wantErr) == 0 {
+				if err != nil {
+					t.Fatalf("loadConfig() error = %v", err)
+				}
+
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("loadConfig() got = %+v, want %+v", got, tt.want)
+				}
+
+				return
+			}
+
+			if err == nil {
+				t.Fatal("loadConfig() did not return error as expected")
+			}
+
+			for _, name := range tt.wantErr {
+				if !strings.Contains(err.Error(), name) {
+					t.Errorf("loadConfig() error = %v, want error about %v", err, name)
+				}
+			}
+		})
+	}
+}
+
+func Test_newServiceFromConfig(t *testing.T) {
+
+	config := Config{SecretsStorage: "PARAMETER_STORE", SecretsPrefix: "/", ProvidersFile: "does-not-exist.json"}
+
+	if _, err := newServiceFromConfig(context.TODO(), config); err == nil {
+		t.Error("newServiceFromConfig() did not return error as expected")
+	}
+}
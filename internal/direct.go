@@ -0,0 +1,73 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/catnekaise/ghrawel-tokenprovider-lambda-go/pkg/api"
+)
+
+// handleDirectInvoke serves an api.InvokeRequest sent with lambda:Invoke. Invoking the function is authorized
+// by IAM, so there is no identity in the payload and the caller is taken from the invoke context instead.
+func (s *Service) handleDirectInvoke(ctx context.Context, req api.InvokeRequest) *api.InvokeResponse {
+
+	if req.Version != api.InvokeVersion {
+		s.logger.InfoContext(ctx, fmt.Sprintf("InputError - Unsupported version %q of direct invocation", req.Version))
+		return newInvokeResponse(nil, createErrorResponse(api.ErrorCodeInvalidRequest, fmt.Sprintf("Version must be %q", api.InvokeVersion), 400))
+	}
+
+	input, e := s.providerInput(ctx, req.Provider, req.TokenRequest)
+
+	if e != nil {
+		return newInvokeResponse(nil, e)
+	}
+
+	return newInvokeResponse(s.serve(ctx, input, directInvokeCaller(ctx)))
+}
+
+// directInvokeCaller reads the caller from the invoke context. The client context is set by the caller
+// itself, so userArn and user of its custom values are only used to tell callers apart in logs.
+func directInvokeCaller(ctx context.Context) api.Caller {
+
+	var caller api.Caller
+
+	lc, ok := lambdacontext.FromContext(ctx)
+
+	if !ok {
+		return caller
+	}
+
+	caller.RequestId = lc.AwsRequestID
+	caller.CognitoIdentityId = lc.Identity.CognitoIdentityID
+	caller.CognitoIdentityPoolId = lc.Identity.CognitoIdentityPoolID
+	caller.UserArn = lc.ClientContext.Custom["userArn"]
+	caller.User = lc.ClientContext.Custom["user"]
+
+	return caller
+}
+
+func newInvokeResponse(result any, err error) *api.InvokeResponse {
+
+	response := &api.InvokeResponse{Version: api.InvokeVersion}
+
+	var e *errorResponse
+
+	if err != nil && !errors.As(err, &e) {
+		e = createErrorResponse(api.ErrorCodeInternal, "Error", 500)
+	}
+
+	if e != nil {
+		response.Error = &api.Error{Code: e.Code, Message: e.Message, StatusCode: e.statusCode, RetryAfter: e.RetryAfter}
+		return response
+	}
+
+	switch result := result.(type) {
+	case *api.TokenResponse:
+		response.Token = result
+	case *api.RevokeResponse:
+		response.Revoke = result
+	}
+
+	return response
+}
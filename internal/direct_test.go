@@ -0,0 +1,131 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/catnekaise/ghrawel-tokenprovider-lambda-go/pkg/api"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_invoke_direct(t *testing.T) {
+
+	t.Setenv("GITHUB_APP_PRIVATE_KEY_DEFAULT", createTestPrivateKey(t))
+
+	server := createTestEnterpriseServer(t)
+	providersFile := filepath.Join(t.TempDir(), "providers.json")
+
+	err := os.WriteFile(providersFile, []byte(fmt.Sprintf(`{
+		"providers": [
+			{
+				"providerName": "example",
+				"permissions": {"contents": "read"},
+				"app": {"id": 1234, "name": "default", "baseUrl": %q},
+				"endpoint": {"type": "STATIC_OWNER"},
+				"targetRule": {"repositorySelectionMode": "AT_LEAST_ONE"},
+				"owner": "catnekaise"
+			}
+		]
+	}`, server.URL)), 0600)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := loadProviders(providersFile)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	service := newTestService(t, Options{Providers: loaded, AllowedBaseUrls: []string{server.URL}})
+
+	ctx := lambdacontext.NewContext(context.TODO(), &lambdacontext.LambdaContext{
+		AwsRequestID:  "c6af9ac6-7b61-11e6-9a41-93e8deadbeef",
+		ClientContext: lambdacontext.ClientContext{Custom: map[string]string{"userArn": "arn:aws:sts::123456789012:assumed-role/example/build"}},
+	})
+
+	tests := []struct {
+		name      string
+		payload   string
+		wantToken string
+		wantError *api.Error
+	}{
+		{
+			name:      "token",
+			payload:   `{"version": "1", "provider": "example", "repo": "example-repo"}`,
+			wantToken: "ghs_example",
+		},
+		{
+			name:      "unsupported version",
+			payload:   `{"version": "2", "provider": "example", "repo": "example-repo"}`,
+			wantError: &api.Error{Code: api.ErrorCodeInvalidRequest, Message: `Version must be "1"`, StatusCode: 400},
+		},
+		{
+			name:      "unknown provider",
+			payload:   `{"version": "1", "provider": "unknown", "repo": "example-repo"}`,
+			wantError: &api.Error{Code: api.ErrorCodeProviderNotFound, Message: "Token provider not found", StatusCode: 404},
+		},
+		{
+			name:      "invalid repo",
+			payload:   `{"version": "1", "provider": "example", "repo": "example-repo#"}`,
+			wantError: &api.Error{Code: api.ErrorCodeInvalidRepositorySelection, Message: "Invalid repository selection.", StatusCode: 400},
+		},
+		{
+			name:      "invalid payload",
+			payload:   `{"version": 1}`,
+			wantError: &api.Error{Code: api.ErrorCodeInvalidRequest, Message: "Error", StatusCode: 400},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := service.invoke(ctx, json.RawMessage(tt.payload))
+
+			if err != nil {
+				t.Fatalf("invoke() error = %v", err)
+			}
+
+			response, ok := got.(*api.InvokeResponse)
+
+			if !ok {
+				t.Fatalf("invoke() got = %T", got)
+			}
+
+			if response.Version != api.InvokeVersion {
+				t.Errorf("invoke() version = %v, want %v", response.Version, api.InvokeVersion)
+			}
+
+			if tt.wantError != nil {
+				if response.Error == nil || *response.Error != *tt.wantError {
+					t.Errorf("invoke() error = %+v, want %+v", response.Error, tt.wantError)
+				}
+				return
+			}
+
+			if response.Error != nil || response.Token == nil || response.Token.Token != tt.wantToken {
+				t.Errorf("invoke() got = %+v, want token %v", response, tt.wantToken)
+			}
+		})
+	}
+}
+
+func Test_directInvokeCaller(t *testing.T) {
+
+	ctx := lambdacontext.NewContext(context.TODO(), &lambdacontext.LambdaContext{
+		AwsRequestID:  "c6af9ac6-7b61-11e6-9a41-93e8deadbeef",
+		ClientContext: lambdacontext.ClientContext{Custom: map[string]string{"userArn": "arn:aws:iam::123456789012:role/example", "user": "build"}},
+	})
+
+	want := api.Caller{RequestId: "c6af9ac6-7b61-11e6-9a41-93e8deadbeef", UserArn: "arn:aws:iam::123456789012:role/example", User: "build"}
+
+	if got := directInvokeCaller(ctx); got != want {
+		t.Errorf("directInvokeCaller() got = %+v, want %+v", got, want)
+	}
+
+	if got := directInvokeCaller(context.TODO()); got != (api.Caller{}) {
+		t.Errorf("directInvokeCaller() got = %+v, want empty caller", got)
+	}
+}
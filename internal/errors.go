@@ -0,0 +1,123 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/catnekaise/ghrawel-tokenprovider-lambda-go/pkg/api"
+	"github.com/google/go-github/v60/github"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// errorResponse is returned as the error of the handler. Its message is the JSON the API Gateway integration
+// matches selectionPattern against.
+type errorResponse struct {
+	SelectionPattern string `json:"selectionPattern"`
+	Code             string `json:"code"`
+	Message          string `json:"message"`
+	// RetryAfter is the number of seconds to wait before retrying, when known.
+	RetryAfter int `json:"retryAfter,omitempty"`
+	statusCode int
+}
+
+func (e *errorResponse) Error() string {
+
+	str, err := json.Marshal(e)
+
+	if err != nil {
+		panic(err)
+	}
+
+	return string(str)
+}
+
+func createErrorResponse(code string, message string, statusCode int) *errorResponse {
+
+	return &errorResponse{
+		SelectionPattern: fmt.Sprintf("CK_ERR_%v", statusCode),
+		Code:             code,
+		Message:          message,
+		statusCode:       statusCode,
+	}
+}
+
+// classifyGitHubError maps a failed call to GitHub to an error response, so that callers can tell a request
+// that has to be changed from one that can be retried later.
+func classifyGitHubError(err error) *errorResponse {
+
+	var rateLimitError *github.RateLimitError
+	var abuseRateLimitError *github.AbuseRateLimitError
+	var errorResponse *github.ErrorResponse
+	var exhaustedError *retriesExhaustedError
+
+	if errors.Is(err, errInstallationNotFound) {
+		return createErrorResponse(api.ErrorCodeInstallationNotFound, "GitHub App is not installed on owner", 404)
+	}
+
+	if errors.As(err, &exhaustedError) && exhaustedError.rateLimited {
+		e := createErrorResponse(api.ErrorCodeRateLimited, "GitHub rate limit exceeded, retry later", 429)
+		e.RetryAfter = retryAfterSeconds(exhaustedError.retryAfter)
+		return e
+	}
+
+	if errors.As(err, &exhaustedError) {
+		e := createErrorResponse(api.ErrorCodeRetriesExhausted, "GitHub did not respond successfully, retry later", 503)
+		e.RetryAfter = retryAfterSeconds(exhaustedError.retryAfter)
+		return e
+	}
+
+	if errors.As(err, &rateLimitError) {
+		e := createErrorResponse(api.ErrorCodeRateLimited, "GitHub rate limit exceeded, retry later", 429)
+		e.RetryAfter = retryAfterSeconds(time.Until(rateLimitError.Rate.Reset.Time))
+		return e
+	}
+
+	if errors.As(err, &abuseRateLimitError) {
+		e := createErrorResponse(api.ErrorCodeRateLimited, "GitHub rate limit exceeded, retry later", 429)
+		e.RetryAfter = retryAfterSeconds(abuseRateLimitError.GetRetryAfter())
+		return e
+	}
+
+	if !errors.As(err, &errorResponse) || errorResponse.Response == nil {
+		return createErrorResponse(api.ErrorCodeGitHubUnavailable, "GitHub could not be reached, retry later", 502)
+	}
+
+	statusCode := errorResponse.Response.StatusCode
+
+	switch {
+	case statusCode == http.StatusNotFound:
+		return createErrorResponse(api.ErrorCodeInstallationNotFound, "GitHub App is not installed on owner", 404)
+	case statusCode == http.StatusUnprocessableEntity && strings.Contains(strings.ToLower(errorResponse.Message), "repositor"):
+		return createErrorResponse(api.ErrorCodeRepositoryNotAccessible, "One or more repositories do not exist or are not accessible to the GitHub App", 422)
+	case statusCode == http.StatusUnprocessableEntity:
+		return createErrorResponse(api.ErrorCodePermissionsNotGranted, "Requested permissions are not granted to the GitHub App", 422)
+	case statusCode == http.StatusForbidden:
+		return createErrorResponse(api.ErrorCodeInstallationForbidden, "GitHub denied the request, the installation may be suspended", 403)
+	case statusCode >= 500:
+		return createErrorResponse(api.ErrorCodeGitHubUnavailable, "GitHub is unavailable, retry later", 502)
+	}
+
+	return createErrorResponse(api.ErrorCodeInternal, "Error", 500)
+}
+
+// retryAfterSeconds rounds the wait up to whole seconds, never returning less than one second.
+func retryAfterSeconds(wait time.Duration) int {
+
+	return max(int((wait+time.Second-1)/time.Second), 1)
+}
+
+// logErrorResponse logs errors caused by the request at info and all other errors at error level.
+func (s *Service) logErrorResponse(ctx context.Context, e *errorResponse, message string) {
+
+	level := slog.LevelError
+
+	if e.statusCode < 500 {
+		level = slog.LevelInfo
+	}
+
+	s.logger.Log(ctx, level, message, slog.String("errorCode", e.Code))
+}
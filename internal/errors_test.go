@@ -0,0 +1,108 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"github.com/catnekaise/ghrawel-tokenprovider-lambda-go/pkg/api"
+	"github.com/google/go-github/v60/github"
+	"net/http"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func Test_classifyGitHubError(t *testing.T) {
+
+	githubError := func(statusCode int, message string) error {
+		return &github.ErrorResponse{Response: &http.Response{StatusCode: statusCode}, Message: message}
+	}
+
+	tests := []struct {
+		name           string
+		err            error
+		wantCode       string
+		wantStatusCode int
+	}{
+		{
+			name:           "installation not found",
+			err:            fmt.Errorf("lookup: %w", errInstallationNotFound),
+			wantCode:       api.ErrorCodeInstallationNotFound,
+			wantStatusCode: 404,
+		},
+		{
+			name:           "permissions not granted",
+			err:            githubError(422, "The permissions requested are not granted to this installation."),
+			wantCode:       api.ErrorCodePermissionsNotGranted,
+			wantStatusCode: 422,
+		},
+		{
+			name:           "repository not accessible",
+			err:            githubError(422, "There is at least one repository that does not exist or is not accessible to the parent installation."),
+			wantCode:       api.ErrorCodeRepositoryNotAccessible,
+			wantStatusCode: 422,
+		},
+		{
+			name:           "suspended installation",
+			err:            githubError(403, "This installation has been suspended"),
+			wantCode:       api.ErrorCodeInstallationForbidden,
+			wantStatusCode: 403,
+		},
+		{
+			name:           "rate limited",
+			err:            &github.RateLimitError{Response: &http.Response{StatusCode: 403}},
+			wantCode:       api.ErrorCodeRateLimited,
+			wantStatusCode: 429,
+		},
+		{
+			name:           "secondary rate limit",
+			err:            &github.AbuseRateLimitError{Response: &http.Response{StatusCode: 403}},
+			wantCode:       api.ErrorCodeRateLimited,
+			wantStatusCode: 429,
+		},
+		{
+			name:           "retries exhausted",
+			err:            &url.Error{Op: "Post", URL: "https://api.github.com", Err: &retriesExhaustedError{attempts: 3, statusCode: 502}},
+			wantCode:       api.ErrorCodeRetriesExhausted,
+			wantStatusCode: 503,
+		},
+		{
+			name:           "rate limit wait beyond max wait",
+			err:            &url.Error{Op: "Post", URL: "https://api.github.com", Err: &retriesExhaustedError{attempts: 1, statusCode: 429, rateLimited: true, retryAfter: time.Minute}},
+			wantCode:       api.ErrorCodeRateLimited,
+			wantStatusCode: 429,
+		},
+		{
+			name:           "bad gateway",
+			err:            githubError(502, ""),
+			wantCode:       api.ErrorCodeGitHubUnavailable,
+			wantStatusCode: 502,
+		},
+		{
+			name:           "connection error",
+			err:            errors.New("dial tcp: connection refused"),
+			wantCode:       api.ErrorCodeGitHubUnavailable,
+			wantStatusCode: 502,
+		},
+		{
+			name:           "unexpected status",
+			err:            githubError(400, "Bad request"),
+			wantCode:       api.ErrorCodeInternal,
+			wantStatusCode: 500,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := classifyGitHubError(tt.err)
+
+			if got.Code != tt.wantCode {
+				t.Errorf("classifyGitHubError() got code = %v, want %v", got.Code, tt.wantCode)
+			}
+
+			errCode := fmt.Sprintf("CK_ERR_%v", tt.wantStatusCode)
+			if !regexp.MustCompile(errCode).MatchString(got.Error()) {
+				t.Errorf("classifyGitHubError() got = %v, want %v", got.Error(), errCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package internal
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	dialTimeout           = 3 * time.Second
+	tlsHandshakeTimeout   = 3 * time.Second
+	responseHeaderTimeout = 10 * time.Second
+	idleConnTimeout       = 90 * time.Second
+	maxIdleConnsPerHost   = 10
+	// deadlineSafetyMargin is left of the invocation for returning an error response when GitHub is slow.
+	deadlineSafetyMargin = time.Second
+)
+
+// githubTransport is shared by every client so that connections to GitHub are kept alive across warm
+// invocations.
+var githubTransport = newRetryTransport(newHTTPTransport())
+
+// newHTTPTransport returns a transport that gives up on a hung connection instead of using up the remaining
+// time of the invocation.
+func newHTTPTransport() *http.Transport {
+
+	dialer := &net.Dialer{
+		Timeout:   dialTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+
+	return &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		TLSHandshakeTimeout:   tlsHandshakeTimeout,
+		ResponseHeaderTimeout: responseHeaderTimeout,
+		ExpectContinueTimeout: time.Second,
+		IdleConnTimeout:       idleConnTimeout,
+		MaxIdleConns:          maxIdleConnsPerHost,
+		MaxIdleConnsPerHost:   maxIdleConnsPerHost,
+	}
+}
+
+// contextWithDeadlineMargin ends the context a safety margin before the deadline of the invocation, so that
+// calls to GitHub are cancelled while there still is time to log and return an error response.
+func contextWithDeadlineMargin(ctx context.Context) (context.Context, context.CancelFunc) {
+
+	deadline, ok := ctx.Deadline()
+
+	if !ok {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithDeadline(ctx, deadline.Add(-deadlineSafetyMargin))
+}
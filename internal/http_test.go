@@ -0,0 +1,36 @@
+package internal
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func Test_contextWithDeadlineMargin(t *testing.T) {
+
+	t.Run("invocation deadline", func(t *testing.T) {
+
+		deadline := time.Now().Add(10 * time.Second)
+		parent, cancelParent := context.WithDeadline(context.Background(), deadline)
+		defer cancelParent()
+
+		ctx, cancel := contextWithDeadlineMargin(parent)
+		defer cancel()
+
+		got, ok := ctx.Deadline()
+
+		if !ok || !got.Equal(deadline.Add(-deadlineSafetyMargin)) {
+			t.Errorf("contextWithDeadlineMargin() deadline = %v, want %v", got, deadline.Add(-deadlineSafetyMargin))
+		}
+	})
+
+	t.Run("no deadline", func(t *testing.T) {
+
+		ctx, cancel := contextWithDeadlineMargin(context.Background())
+		defer cancel()
+
+		if _, ok := ctx.Deadline(); ok {
+			t.Error("contextWithDeadlineMargin() set a deadline without an invocation deadline")
+		}
+	})
+}
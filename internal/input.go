@@ -1,16 +1,23 @@
 package internal
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/catnekaise/ghrawel-tokenprovider-lambda-go/pkg/api"
 	"log/slog"
+	"path"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 )
 
 var ownerRegex = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9-]+$`)
-var repoRegex = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_.-]+$`)
+var repoRegex = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]+$`)
+var repoIdRegex = regexp.MustCompile(`^id:[1-9][0-9]{0,18}$`)
+var selectorRegex = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+var tokenRegex = regexp.MustCompile(`^ghs_[a-zA-Z0-9_]+$`)
 
 func isRepositorySelectionMode(value string) (bool, error) {
 
@@ -19,6 +26,12 @@ func isRepositorySelectionMode(value string) (bool, error) {
 		return true, nil
 	case api.RepositorySelectionModeAllowOwner:
 		return true, nil
+	case api.RepositorySelectionModeByProperty:
+		return true, nil
+	case api.RepositorySelectionModeByTopic:
+		return true, nil
+	case api.RepositorySelectionModeByTeam:
+		return true, nil
 	}
 
 	return false, errors.New("invalid repository selection mode")
@@ -44,7 +57,78 @@ func readOwner(owner string) bool {
 	return ownerRegex.MatchString(owner)
 }
 
-func readRepo(endpointType string, repositorySelectionMode string, repo *string) ([]string, error) {
+// isOwnerAllowed reports whether the owner matches one of the allowed owners, where no allowed owners allow
+// every owner. Owners are matched ignoring case, like installations are looked up.
+func isOwnerAllowed(allowedOwners []string, owner string) (bool, error) {
+
+	if len(allowedOwners) == 0 {
+		return true, nil
+	}
+
+	for _, pattern := range allowedOwners {
+
+		ok, err := matchPattern(pattern, owner)
+
+		if err != nil {
+			return false, err
+		}
+
+		if ok {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// readSelector returns the topic or team the repositories of the selection mode are selected by, or an empty
+// string for the other selection modes.
+func readSelector(repositorySelectionMode string, tokenRequest api.TokenRequest) (string, error) {
+
+	var value *string
+
+	switch repositorySelectionMode {
+	case api.RepositorySelectionModeByTopic:
+		value = tokenRequest.Topic
+	case api.RepositorySelectionModeByTeam:
+		value = tokenRequest.Team
+	default:
+		return "", nil
+	}
+
+	if value == nil || !selectorRegex.MatchString(*value) {
+		return "", errors.New(fmt.Sprintf("repository selection mode %s requires a valid topic or team", repositorySelectionMode))
+	}
+
+	return *value, nil
+}
+
+func readToken(token *string) (string, bool) {
+
+	if token == nil || !tokenRegex.MatchString(*token) {
+		return "", false
+	}
+
+	return *token, true
+}
+
+// repositoryNotAllowedError is returned by readRepo when the target rule does not allow the repositories.
+type repositoryNotAllowedError struct {
+	repositories []string
+}
+
+func (e *repositoryNotAllowedError) Error() string {
+
+	if len(e.repositories) == 0 {
+		return "all repositories of owner cannot be selected when the target rule restricts repositories"
+	}
+
+	return fmt.Sprintf("repositories %s are not allowed by the target rule", strings.Join(e.repositories, ", "))
+}
+
+func readRepo(ctx context.Context, logger *slog.Logger, endpointType string, targetRule api.TargetRule, repo *string) ([]string, error) {
+
+	repositorySelectionMode := targetRule.RepositorySelectionMode
 
 	var repositories []string
 
@@ -64,21 +148,33 @@ func readRepo(endpointType string, repositorySelectionMode string, repo *string)
 
 		break
 	case api.RepositorySelectionModeAllowOwner:
+		// a token without repositories has access to all repositories of the installation, whatever the endpoint
+		if len(repositories) == 0 && hasRepositoryPatterns(targetRule) {
+			return nil, &repositoryNotAllowedError{}
+		}
+
 		if api.IsOwnerEndpoint(endpointType) && len(repositories) == 0 {
 			return nil, nil
 		}
 		break
+	case api.RepositorySelectionModeByProperty:
+		if len(repositories) != 0 {
+			return nil, errors.New("repositories are selected by custom properties and cannot be specified")
+		}
+
+		return nil, nil
 	}
 
 	invalid := false
 
 	for _, repository := range repositories {
 
-		valid := repoRegex.MatchString(repository)
+		_, isId := readRepoId(repository)
+		valid := isId || repoRegex.MatchString(repository)
 
 		if !valid {
 			invalid = true
-			slog.Debug(fmt.Sprintf("Invalid name (%q) provided for repository", repository))
+			logger.DebugContext(ctx, fmt.Sprintf("Invalid name (%q) provided for repository", repository))
 		}
 	}
 
@@ -86,6 +182,163 @@ func readRepo(endpointType string, repositorySelectionMode string, repo *string)
 		return nil, errors.New(fmt.Sprintf("One or more repositories specified with an invalid name"))
 	}
 
+	var rejected []string
+
+	for _, repository := range repositories {
+
+		name := repository
+		id, isId := readRepoId(repository)
+
+		if isId {
+			name = ""
+		}
+
+		allowed, err := isRepositoryAllowed(targetRule, name, id)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !allowed {
+			rejected = append(rejected, repository)
+		}
+	}
+
+	if len(rejected) > 0 {
+		return nil, &repositoryNotAllowedError{repositories: rejected}
+	}
+
 	return repositories, nil
 
 }
+
+func hasRepositoryPatterns(targetRule api.TargetRule) bool {
+
+	return len(targetRule.AllowRepositories) > 0 || len(targetRule.DenyRepositories) > 0 || len(targetRule.RepositoryIds) > 0
+}
+
+// readRepoId returns the ID of a repository specified as id:<ID>. Other values, including numeric ones, are
+// repository names.
+func readRepoId(repository string) (int64, bool) {
+
+	if !repoIdRegex.MatchString(repository) {
+		return 0, false
+	}
+
+	id, err := strconv.ParseInt(strings.TrimPrefix(repository, "id:"), 10, 64)
+
+	return id, err == nil
+}
+
+// isRepositoryAllowed matches the repository against the allowlist and the deny patterns of the target rule.
+// Either name or id may be unknown. A repository known only by ID has to be pinned by the target rule when
+// it has any patterns, since its name cannot be matched before the token is created.
+func isRepositoryAllowed(targetRule api.TargetRule, name string, id int64) (bool, error) {
+
+	if id != 0 && slices.Contains(targetRule.RepositoryIds, id) {
+		return true, nil
+	}
+
+	if name == "" {
+		return !hasRepositoryPatterns(targetRule), nil
+	}
+
+	allowed := len(targetRule.AllowRepositories) == 0 && len(targetRule.RepositoryIds) == 0
+
+	for _, pattern := range targetRule.AllowRepositories {
+
+		ok, err := matchPattern(pattern, name)
+
+		if err != nil {
+			return false, err
+		}
+
+		if ok {
+			allowed = true
+			break
+		}
+	}
+
+	for _, pattern := range targetRule.DenyRepositories {
+
+		ok, err := matchPattern(pattern, name)
+
+		if err != nil {
+			return false, err
+		}
+
+		if ok {
+			return false, nil
+		}
+	}
+
+	return allowed, nil
+}
+
+// matchPattern matches a repository or owner name against a regular expression between slashes, or else
+// against a glob, ignoring case like GitHub does.
+func matchPattern(pattern string, name string) (bool, error) {
+
+	if len(pattern) > 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+
+		re, err := regexp.Compile("(?i)" + pattern[1:len(pattern)-1])
+
+		if err != nil {
+			return false, errors.New(fmt.Sprintf("invalid pattern %q: %s", pattern, err.Error()))
+		}
+
+		return re.MatchString(name), nil
+	}
+
+	ok, err := path.Match(strings.ToLower(pattern), strings.ToLower(name))
+
+	if err != nil {
+		return false, errors.New(fmt.Sprintf("invalid pattern %q: %s", pattern, err.Error()))
+	}
+
+	return ok, nil
+}
+
+// checkPropertyFilters reports whether the target rule has the property filters its selection mode needs.
+func checkPropertyFilters(targetRule api.TargetRule) error {
+
+	if targetRule.RepositorySelectionMode != api.RepositorySelectionModeByProperty {
+		return nil
+	}
+
+	if len(targetRule.Properties) == 0 {
+		return errors.New(fmt.Sprintf("repository selection mode %s requires properties", targetRule.RepositorySelectionMode))
+	}
+
+	for _, filter := range targetRule.Properties {
+		if filter.Name == "" || len(filter.Values) == 0 {
+			return errors.New(fmt.Sprintf("property filter %q requires a name and values", filter.Name))
+		}
+	}
+
+	return nil
+}
+
+// checkPatterns reports the first owner or repository pattern, or pinned repository ID, of the token context
+// that is invalid.
+func checkPatterns(tokenContext api.TokenContext) error {
+
+	targetRule := tokenContext.TargetRule
+	patterns := append(append(append([]string{}, tokenContext.AllowedOwners...), targetRule.AllowRepositories...), targetRule.DenyRepositories...)
+
+	for _, pattern := range patterns {
+
+		if _, err := matchPattern(pattern, ""); err != nil {
+			return err
+		}
+	}
+
+	for _, id := range targetRule.RepositoryIds {
+
+		if id <= 0 {
+			return errors.New(fmt.Sprintf("invalid repository id %v", id))
+		}
+	}
+
+	return nil
+}
@@ -1,7 +1,11 @@
 package internal
 
 import (
+	"context"
+	"errors"
+	"github.com/catnekaise/ghrawel-tokenprovider-lambda-go/pkg/api"
 	"github.com/google/go-github/v60/github"
+	"log/slog"
 	"reflect"
 	"testing"
 )
@@ -101,7 +105,7 @@ func Test_readRepositories(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := readRepo(tt.args.endpointType, tt.args.repositorySelectionMode, tt.args.repo)
+			got, err := readRepo(context.TODO(), slog.Default(), tt.args.endpointType, api.TargetRule{RepositorySelectionMode: tt.args.repositorySelectionMode}, tt.args.repo)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("readRepo() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -112,3 +116,171 @@ func Test_readRepositories(t *testing.T) {
 		})
 	}
 }
+
+func Test_readRepo_targetRulePatterns(t *testing.T) {
+
+	rule := api.TargetRule{
+		RepositorySelectionMode: api.RepositorySelectionModeAllowOwner,
+		AllowRepositories:       []string{"infra-*", "/^app-[0-9]+$/"},
+		DenyRepositories:        []string{"infra-secrets"},
+	}
+
+	tests := []struct {
+		name         string
+		endpointType string
+		rule         api.TargetRule
+		repo         *string
+		want         []string
+		wantRejected []string
+		wantErr      bool
+	}{
+		{name: "glob allows", endpointType: "STATIC_OWNER", rule: rule, repo: github.String("infra-network"), want: []string{"infra-network"}},
+		{name: "glob ignores case", endpointType: "STATIC_OWNER", rule: rule, repo: github.String("Infra-Network"), want: []string{"Infra-Network"}},
+		{name: "regex allows", endpointType: "STATIC_OWNER", rule: rule, repo: github.String("app-12,infra-dns"), want: []string{"app-12", "infra-dns"}},
+		{name: "not in allow list", endpointType: "STATIC_OWNER", rule: rule, repo: github.String("infra-dns,app-x,web"), wantRejected: []string{"app-x", "web"}, wantErr: true},
+		{name: "deny regex ignores case", endpointType: "STATIC_OWNER", rule: api.TargetRule{RepositorySelectionMode: api.RepositorySelectionModeAllowOwner, DenyRepositories: []string{"/^infra-secrets$/"}}, repo: github.String("Infra-Secrets"), wantRejected: []string{"Infra-Secrets"}, wantErr: true},
+		{name: "deny wins over allow", endpointType: "STATIC_OWNER", rule: rule, repo: github.String("infra-secrets"), wantRejected: []string{"infra-secrets"}, wantErr: true},
+		{name: "deny only", endpointType: "DEFAULT", rule: api.TargetRule{RepositorySelectionMode: api.RepositorySelectionModeAtLeastOne, DenyRepositories: []string{"*-prod"}}, repo: github.String("web-prod"), wantRejected: []string{"web-prod"}, wantErr: true},
+		{name: "deny only allows others", endpointType: "DEFAULT", rule: api.TargetRule{RepositorySelectionMode: api.RepositorySelectionModeAtLeastOne, DenyRepositories: []string{"*-prod"}}, repo: github.String("web-dev"), want: []string{"web-dev"}},
+		{name: "all repositories of owner", endpointType: "STATIC_OWNER", rule: rule, repo: nil, wantRejected: []string{}, wantErr: true},
+		{name: "all repositories on default endpoint", endpointType: "DEFAULT", rule: rule, repo: nil, wantRejected: []string{}, wantErr: true},
+		{name: "all repositories on default endpoint without rules", endpointType: "DEFAULT", rule: api.TargetRule{RepositorySelectionMode: api.RepositorySelectionModeAllowOwner}, repo: nil, want: []string{}},
+		{name: "invalid pattern", endpointType: "STATIC_OWNER", rule: api.TargetRule{RepositorySelectionMode: api.RepositorySelectionModeAllowOwner, AllowRepositories: []string{"/(/"}}, repo: github.String("web"), wantErr: true},
+		{name: "repository ids without rules", endpointType: "STATIC_OWNER", rule: api.TargetRule{RepositorySelectionMode: api.RepositorySelectionModeAllowOwner}, repo: github.String("id:42,web"), want: []string{"id:42", "web"}},
+		{name: "pinned repository id", endpointType: "STATIC_OWNER", rule: api.TargetRule{RepositorySelectionMode: api.RepositorySelectionModeAllowOwner, DenyRepositories: []string{"*"}, RepositoryIds: []int64{42}}, repo: github.String("id:42"), want: []string{"id:42"}},
+		{name: "repository id not pinned", endpointType: "STATIC_OWNER", rule: rule, repo: github.String("infra-dns,id:42"), wantRejected: []string{"id:42"}, wantErr: true},
+		{name: "name when only ids are pinned", endpointType: "STATIC_OWNER", rule: api.TargetRule{RepositorySelectionMode: api.RepositorySelectionModeAllowOwner, RepositoryIds: []int64{42}}, repo: github.String("web"), wantRejected: []string{"web"}, wantErr: true},
+		{name: "invalid repository id", endpointType: "STATIC_OWNER", rule: api.TargetRule{RepositorySelectionMode: api.RepositorySelectionModeAllowOwner}, repo: github.String("id:042"), wantErr: true},
+		{name: "numeric repository name", endpointType: "STATIC_OWNER", rule: api.TargetRule{RepositorySelectionMode: api.RepositorySelectionModeAllowOwner, RepositoryIds: []int64{2024}, AllowRepositories: []string{"2024"}}, repo: github.String("2024"), want: []string{"2024"}},
+		{name: "numeric repository name is not an id", endpointType: "STATIC_OWNER", rule: api.TargetRule{RepositorySelectionMode: api.RepositorySelectionModeAllowOwner, RepositoryIds: []int64{2024}}, repo: github.String("2024"), wantRejected: []string{"2024"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readRepo(context.TODO(), slog.Default(), tt.endpointType, tt.rule, tt.repo)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readRepo() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readRepo() got = %v, want %v", got, tt.want)
+			}
+			var notAllowed *repositoryNotAllowedError
+			if (tt.wantRejected != nil) != errors.As(err, &notAllowed) {
+				t.Fatalf("readRepo() error = %v, want rejected %v", err, tt.wantRejected)
+			}
+			if tt.wantRejected != nil && len(tt.wantRejected) > 0 && !reflect.DeepEqual(notAllowed.repositories, tt.wantRejected) {
+				t.Errorf("readRepo() rejected = %v, want %v", notAllowed.repositories, tt.wantRejected)
+			}
+		})
+	}
+}
+
+func Test_validateInput_targetRulePatterns(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		patterns   []string
+		wantErrInt int
+	}{
+		{name: "repository not allowed", patterns: []string{"infra-*"}, wantErrInt: 403},
+		{name: "invalid pattern", patterns: []string{"[infra"}, wantErrInt: 500},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := createTestInput("catnekaise", github.String("example-repo"), nil, nil)
+			req.TokenContext.TargetRule.AllowRepositories = tt.patterns
+
+			_, _, err := (&Service{logger: slog.Default()}).validateInput(context.TODO(), req)
+
+			var e *errorResponse
+			if !errors.As(err, &e) || e.statusCode != tt.wantErrInt {
+				t.Errorf("validateInput() error = %v, want status %v", err, tt.wantErrInt)
+			}
+		})
+	}
+}
+
+func Test_isOwnerAllowed(t *testing.T) {
+
+	tests := []struct {
+		name          string
+		allowedOwners []string
+		owner         string
+		want          bool
+		wantErr       bool
+	}{
+		{name: "no allowed owners", allowedOwners: nil, owner: "catnekaise", want: true},
+		{name: "exact", allowedOwners: []string{"catnekaise"}, owner: "catnekaise", want: true},
+		{name: "exact ignores case", allowedOwners: []string{"catnekaise"}, owner: "CatNekaise", want: true},
+		{name: "glob", allowedOwners: []string{"other", "catnekaise-*"}, owner: "catnekaise-labs", want: true},
+		{name: "regex", allowedOwners: []string{"/^cat[a-z]+$/"}, owner: "catnekaise", want: true},
+		{name: "regex ignores case", allowedOwners: []string{"/^catnekaise$/"}, owner: "CATNEKAISE", want: true},
+		{name: "not allowed", allowedOwners: []string{"catnekaise", "catnekaise-*"}, owner: "dognekaise", want: false},
+		{name: "invalid pattern", allowedOwners: []string{"[cat"}, owner: "catnekaise", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := isOwnerAllowed(tt.allowedOwners, tt.owner)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("isOwnerAllowed() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("isOwnerAllowed() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_validateInput_allowedOwners(t *testing.T) {
+
+	tests := []struct {
+		name          string
+		allowedOwners []string
+		owner         string
+		wantErrInt    int
+	}{
+		{name: "allowed owner", allowedOwners: []string{"catnekaise-*"}, owner: "catnekaise-labs"},
+		{name: "owner not allowed", allowedOwners: []string{"catnekaise-*"}, owner: "dognekaise", wantErrInt: 403},
+		{name: "invalid pattern", allowedOwners: []string{"[cat"}, owner: "catnekaise", wantErrInt: 500},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := createTestInput(tt.owner, github.String("example-repo"), github.String("DYNAMIC_OWNER"), nil)
+			req.TokenContext.AllowedOwners = tt.allowedOwners
+
+			_, _, err := (&Service{logger: slog.Default()}).validateInput(context.TODO(), req)
+
+			if tt.wantErrInt == 0 {
+				if err != nil {
+					t.Errorf("validateInput() error = %v", err)
+				}
+				return
+			}
+
+			var e *errorResponse
+			if !errors.As(err, &e) || e.statusCode != tt.wantErrInt {
+				t.Errorf("validateInput() error = %v, want status %v", err, tt.wantErrInt)
+			}
+		})
+	}
+}
+
+func Test_checkPropertyFilters(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		targetRule api.TargetRule
+		wantErr    bool
+	}{
+		{name: "other selection mode", targetRule: api.TargetRule{RepositorySelectionMode: api.RepositorySelectionModeAtLeastOne}},
+		{name: "filters", targetRule: api.TargetRule{RepositorySelectionMode: api.RepositorySelectionModeByProperty, Properties: []api.PropertyFilter{{Name: "team", Values: []string{"backend"}}}}},
+		{name: "no filters", targetRule: api.TargetRule{RepositorySelectionMode: api.RepositorySelectionModeByProperty}, wantErr: true},
+		{name: "filter without values", targetRule: api.TargetRule{RepositorySelectionMode: api.RepositorySelectionModeByProperty, Properties: []api.PropertyFilter{{Name: "team"}}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkPropertyFilters(tt.targetRule); (err != nil) != tt.wantErr {
+				t.Errorf("checkPropertyFilters() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package internal
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	installationCacheTtl         = time.Hour
+	installationCacheNegativeTtl = time.Minute
+)
+
+// installationKey identifies an owner per app and GitHub host, since apps on different GitHub Enterprise
+// Servers may share ids and owner logins.
+type installationKey struct {
+	baseUrl string
+	appId   int64
+	owner   string
+}
+
+type installationEntry struct {
+	installationId int64
+	found          bool
+	expiresAt      time.Time
+}
+
+// installationCache remembers which installation belongs to an owner per app across warm invocations.
+// Owners the app is not installed on are remembered for a shorter period so that a new installation is
+// picked up quickly.
+type installationCache struct {
+	mu          sync.Mutex
+	ttl         time.Duration
+	negativeTtl time.Duration
+	now         func() time.Time
+	entries     map[installationKey]installationEntry
+}
+
+func newInstallationCache(ttl time.Duration, negativeTtl time.Duration) *installationCache {
+
+	return &installationCache{
+		ttl:         ttl,
+		negativeTtl: negativeTtl,
+		now:         time.Now,
+		entries:     map[installationKey]installationEntry{},
+	}
+}
+
+// get returns the cached installation id and whether the app is installed on owner. ok is false when
+// nothing, or only an expired entry, is cached.
+func (c *installationCache) get(baseUrl string, appId int64, owner string) (installationId int64, found bool, ok bool) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := newInstallationKey(baseUrl, appId, owner)
+	entry, ok := c.entries[key]
+
+	if !ok {
+		return 0, false, false
+	}
+
+	if !c.now().Before(entry.expiresAt) {
+		delete(c.entries, key)
+		return 0, false, false
+	}
+
+	return entry.installationId, entry.found, true
+}
+
+func (c *installationCache) set(baseUrl string, appId int64, owner string, installationId int64) {
+
+	c.put(baseUrl, appId, owner, installationEntry{installationId: installationId, found: true, expiresAt: c.now().Add(c.ttl)})
+}
+
+func (c *installationCache) setNotFound(baseUrl string, appId int64, owner string) {
+
+	c.put(baseUrl, appId, owner, installationEntry{found: false, expiresAt: c.now().Add(c.negativeTtl)})
+}
+
+func (c *installationCache) invalidate(baseUrl string, appId int64, owner string) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, newInstallationKey(baseUrl, appId, owner))
+}
+
+func (c *installationCache) put(baseUrl string, appId int64, owner string, entry installationEntry) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+
+	for key, existing := range c.entries {
+		if !now.Before(existing.expiresAt) {
+			delete(c.entries, key)
+		}
+	}
+
+	c.entries[newInstallationKey(baseUrl, appId, owner)] = entry
+}
+
+func newInstallationKey(baseUrl string, appId int64, owner string) installationKey {
+
+	return installationKey{baseUrl: baseUrl, appId: appId, owner: strings.ToLower(owner)}
+}
@@ -0,0 +1,58 @@
+package internal
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_installationCache(t *testing.T) {
+
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	cache := newInstallationCache(time.Hour, time.Minute)
+	cache.now = func() time.Time { return now }
+
+	github := "https://api.github.com/"
+
+	cache.set(github, 1, "Catnekaise", 10)
+	cache.setNotFound(github, 1, "unknown")
+
+	if id, found, ok := cache.get(github, 1, "catnekaise"); !ok || !found || id != 10 {
+		t.Errorf("get() got = %v, %v, %v, want 10, true, true", id, found, ok)
+	}
+
+	if _, _, ok := cache.get(github, 2, "catnekaise"); ok {
+		t.Error("get() returned entry cached for another app")
+	}
+
+	if _, _, ok := cache.get("https://github.example.com/api/v3/", 1, "catnekaise"); ok {
+		t.Error("get() returned entry cached for another GitHub host")
+	}
+
+	if _, found, ok := cache.get(github, 1, "unknown"); !ok || found {
+		t.Errorf("get() got = %v, %v, want negative entry", found, ok)
+	}
+
+	now = now.Add(2 * time.Minute)
+
+	if _, _, ok := cache.get(github, 1, "unknown"); ok {
+		t.Error("get() returned expired negative entry")
+	}
+
+	if _, _, ok := cache.get(github, 1, "catnekaise"); !ok {
+		t.Error("get() did not return entry before ttl")
+	}
+
+	cache.invalidate(github, 1, "catnekaise")
+
+	if _, _, ok := cache.get(github, 1, "catnekaise"); ok {
+		t.Error("get() returned invalidated entry")
+	}
+
+	cache.set(github, 1, "catnekaise", 10)
+	now = now.Add(time.Hour)
+	cache.set(github, 1, "other", 11)
+
+	if len(cache.entries) != 1 {
+		t.Errorf("expired entries were not evicted, got %v entries", len(cache.entries))
+	}
+}
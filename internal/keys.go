@@ -0,0 +1,240 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/bradleyfalzon/ghinstallation/v2"
+	"github.com/catnekaise/ghrawel-tokenprovider-lambda-go/pkg/api"
+	"github.com/catnekaise/ghrawel-tokenprovider-lambda-go/pkg/keys"
+	"github.com/google/go-github/v60/github"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// appKey is a private key of an app and the client authenticating as the app with it.
+type appKey struct {
+	id        string
+	version   string
+	transport *ghinstallation.AppsTransport
+	client    *github.Client
+	rejected  bool
+}
+
+type appTransportEntry struct {
+	appId     int64
+	baseUrl   string
+	version   string
+	keys      []*appKey
+	expiresAt time.Time
+}
+
+// appTransportCache keeps the transports built from the private keys of each app across warm invocations.
+// Once an entry expires the private keys are read again and the transports are only rebuilt when the version
+// of the parameters or secret has changed, so a rotated key is picked up without waiting for a cold start.
+// The private keys of an app are read by one caller at a time and without holding the lock, so reading them
+// does not hold up callers using the keys of other apps.
+type appTransportCache struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	now       func() time.Time
+	entries   map[string]*appTransportEntry
+	loading   map[string]chan struct{}
+	fetch     func(ctx context.Context, name string) ([]keys.Key, error)
+	transport http.RoundTripper
+	logger    *slog.Logger
+}
+
+func newAppTransportCache(ttl time.Duration) *appTransportCache {
+
+	return &appTransportCache{
+		ttl:       ttl,
+		now:       time.Now,
+		entries:   map[string]*appTransportEntry{},
+		loading:   map[string]chan struct{}{},
+		transport: githubTransport,
+		logger:    slog.Default(),
+	}
+}
+
+// get returns the keys of the app newest first, with keys GitHub has rejected since they were loaded last.
+func (c *appTransportCache) get(ctx context.Context, app api.App, baseUrl string) ([]appKey, error) {
+
+	for {
+		c.mu.Lock()
+
+		entry, ok := c.entries[app.Name]
+
+		sameApp := ok && entry.appId == app.Id && entry.baseUrl == baseUrl
+
+		if sameApp && c.now().Before(entry.expiresAt) {
+			result := entry.sortedKeys()
+			c.mu.Unlock()
+			return result, nil
+		}
+
+		if done, loading := c.loading[app.Name]; loading {
+			c.mu.Unlock()
+
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-done:
+			}
+
+			continue
+		}
+
+		done := make(chan struct{})
+		c.loading[app.Name] = done
+		c.mu.Unlock()
+
+		if !sameApp {
+			entry = nil
+		}
+
+		loaded, err := c.load(ctx, app, baseUrl, entry)
+
+		c.mu.Lock()
+		delete(c.loading, app.Name)
+		close(done)
+
+		if err != nil {
+			c.mu.Unlock()
+			return nil, err
+		}
+
+		loaded.expiresAt = c.now().Add(c.ttl)
+		c.entries[app.Name] = loaded
+		result := loaded.sortedKeys()
+		c.mu.Unlock()
+
+		return result, nil
+	}
+}
+
+// load reads the private keys of the app and returns the current entry when their version is unchanged, or
+// a new entry with transports built from them otherwise.
+func (c *appTransportCache) load(ctx context.Context, app api.App, baseUrl string, current *appTransportEntry) (*appTransportEntry, error) {
+
+	appKeys, err := c.fetch(ctx, app.Name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(appKeys) == 0 {
+		return nil, errors.New(fmt.Sprintf("no private keys found for %s", app.Name))
+	}
+
+	version := keysVersion(appKeys)
+
+	if current != nil && current.version == version {
+		c.logger.DebugContext(ctx, fmt.Sprintf("Private keys of %s are unchanged at version %s", app.Name, version))
+		return current, nil
+	}
+
+	entry, err := newAppTransportEntry(c.transport, app.Id, baseUrl, version, appKeys)
+
+	if err != nil {
+		return nil, err
+	}
+
+	c.logger.InfoContext(ctx, fmt.Sprintf("Loaded %v private key(s) of %s at version %s", len(appKeys), app.Name, version))
+
+	return entry, nil
+}
+
+// sortedKeys returns copies of the keys with the keys GitHub has rejected last. It is called holding the lock
+// of the cache.
+func (e *appTransportEntry) sortedKeys() []appKey {
+
+	result := make([]appKey, 0, len(e.keys))
+
+	for _, key := range e.keys {
+		if !key.rejected {
+			result = append(result, *key)
+		}
+	}
+
+	for _, key := range e.keys {
+		if key.rejected {
+			result = append(result, *key)
+		}
+	}
+
+	return result
+}
+
+// reject marks a key GitHub did not accept so that the remaining keys are tried first until the keys are
+// read again.
+func (c *appTransportCache) reject(name string, id string) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if entry, ok := c.entries[name]; ok {
+		for _, key := range entry.keys {
+			if key.id == id {
+				key.rejected = true
+			}
+		}
+	}
+}
+
+func (c *appTransportCache) invalidate(name string) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, name)
+}
+
+func newAppTransportEntry(rt http.RoundTripper, appId int64, baseUrl string, version string, appKeys []keys.Key) (*appTransportEntry, error) {
+
+	entry := &appTransportEntry{appId: appId, baseUrl: baseUrl, version: version}
+
+	for _, key := range appKeys {
+
+		var transport *ghinstallation.AppsTransport
+		var err error
+
+		if key.Signer != nil {
+			transport, err = ghinstallation.NewAppsTransportWithOptions(rt, appId, ghinstallation.WithSigner(key.Signer))
+		} else {
+			transport, err = ghinstallation.NewAppsTransport(rt, appId, key.PEM)
+		}
+
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("private key %s: %s", key.Id, err.Error()))
+		}
+
+		client, err := createClient(transport, baseUrl)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if baseUrl != "" {
+			transport.BaseURL = strings.TrimSuffix(client.BaseURL.String(), "/")
+		}
+
+		entry.keys = append(entry.keys, &appKey{id: key.Id, version: key.Version, transport: transport, client: client})
+	}
+
+	return entry, nil
+}
+
+func keysVersion(appKeys []keys.Key) string {
+
+	versions := make([]string, 0, len(appKeys))
+
+	for _, key := range appKeys {
+		versions = append(versions, fmt.Sprintf("%s:%s", key.Id, key.Version))
+	}
+
+	return strings.Join(versions, ",")
+}
@@ -0,0 +1,153 @@
+package internal
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/catnekaise/ghrawel-tokenprovider-lambda-go/pkg/api"
+	"github.com/catnekaise/ghrawel-tokenprovider-lambda-go/pkg/keys"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_appTransportCache(t *testing.T) {
+
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	fetched := 0
+	appKeys := []keys.Key{{Id: "default", PEM: []byte(createTestPrivateKey(t)), Version: "1"}}
+
+	cache := newAppTransportCache(time.Minute)
+	cache.now = func() time.Time { return now }
+	cache.fetch = func(ctx context.Context, name string) ([]keys.Key, error) {
+		fetched++
+		return appKeys, nil
+	}
+
+	app := api.App{Id: 1234, Name: "default"}
+
+	first, err := cache.get(context.TODO(), app, "")
+
+	if err != nil {
+		t.Fatalf("get() error = %v", err)
+	}
+
+	second, _ := cache.get(context.TODO(), app, "")
+
+	if fetched != 1 || first[0].transport != second[0].transport || first[0].client != second[0].client {
+		t.Errorf("get() fetched private key %v times, want 1", fetched)
+	}
+
+	now = now.Add(2 * time.Minute)
+	third, _ := cache.get(context.TODO(), app, "")
+
+	if fetched != 2 || third[0].transport != first[0].transport {
+		t.Errorf("get() rebuilt transport for unchanged version, fetched %v times", fetched)
+	}
+
+	now = now.Add(2 * time.Minute)
+	appKeys = []keys.Key{{Id: "default", PEM: []byte(createTestPrivateKey(t)), Version: "2"}}
+	fourth, _ := cache.get(context.TODO(), app, "")
+
+	if fetched != 3 || fourth[0].transport == first[0].transport {
+		t.Error("get() did not rebuild transport for rotated private key")
+	}
+
+	cache.invalidate(app.Name)
+	_, _ = cache.get(context.TODO(), app, "")
+
+	if fetched != 4 {
+		t.Error("get() did not fetch private key after invalidate")
+	}
+}
+
+func Test_appTransportCache_reject(t *testing.T) {
+
+	cache := newAppTransportCache(time.Minute)
+	cache.fetch = func(ctx context.Context, name string) ([]keys.Key, error) {
+		return []keys.Key{
+			{Id: "new", PEM: []byte(createTestPrivateKey(t)), Version: "2"},
+			{Id: "old", PEM: []byte(createTestPrivateKey(t)), Version: "1"},
+		}, nil
+	}
+
+	app := api.App{Id: 1234, Name: "default"}
+
+	appKeys, err := cache.get(context.TODO(), app, "")
+
+	if err != nil {
+		t.Fatalf("get() error = %v", err)
+	}
+
+	if appKeys[0].id != "new" || appKeys[1].id != "old" {
+		t.Errorf("get() got = %v, %v, want new, old", appKeys[0].id, appKeys[1].id)
+	}
+
+	cache.reject(app.Name, "new")
+	appKeys, _ = cache.get(context.TODO(), app, "")
+
+	if appKeys[0].id != "old" || appKeys[1].id != "new" {
+		t.Errorf("get() got = %v, %v, want old, new", appKeys[0].id, appKeys[1].id)
+	}
+}
+
+func Test_appTransportCache_concurrent(t *testing.T) {
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var fetched atomic.Int32
+	appKeys := []keys.Key{{Id: "default", PEM: []byte(createTestPrivateKey(t)), Version: "1"}}
+
+	cache := newAppTransportCache(time.Minute)
+	cache.fetch = func(ctx context.Context, name string) ([]keys.Key, error) {
+		if fetched.Add(1) == 1 {
+			close(started)
+			<-release
+		}
+		return appKeys, nil
+	}
+
+	app := api.App{Id: 1234, Name: "default"}
+
+	var wg sync.WaitGroup
+
+	get := func() {
+		defer wg.Done()
+
+		if _, err := cache.get(context.TODO(), app, ""); err != nil {
+			t.Errorf("get() error = %v", err)
+		}
+	}
+
+	wg.Add(1)
+	go get()
+	<-started
+
+	// the lock is not held while the private keys are read
+	cache.reject("other", "default")
+	cache.invalidate("other")
+
+	wg.Add(1)
+	go get()
+
+	close(release)
+	wg.Wait()
+
+	if fetched.Load() != 1 {
+		t.Errorf("get() fetched private key %v times, want 1", fetched.Load())
+	}
+}
+
+func createTestPrivateKey(t *testing.T) string {
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))
+}
@@ -2,7 +2,6 @@ package internal
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/aws/aws-lambda-go/lambda"
@@ -10,121 +9,177 @@ import (
 	"log/slog"
 	"os"
 	"regexp"
+	"strings"
 )
 
 var prefixRegex = regexp.MustCompile(`^/$|^/[a-zA-Z][a-zA-Z0-9/-]+[a-zA-Z]$`)
 
 func Start() {
 
-	logger := slog.New(logger{minLevel: level(), jsonHandler: handler()})
+	config, err := loadConfig(os.Getenv)
+
+	logger := slog.New(logger{minLevel: config.level(), next: handler(config.level())})
 	slog.SetDefault(logger)
 
-	lambda.Start(run)
+	if err != nil {
+		slog.Error(fmt.Sprintf("ConfigurationError - %s", strings.ReplaceAll(err.Error(), "\n", "; ")))
+		os.Exit(1)
+	}
+
+	service, err := newServiceFromConfig(context.Background(), config)
+
+	if err != nil {
+		slog.Error(fmt.Sprintf("ConfigurationError - %s", err.Error()))
+		os.Exit(1)
+	}
+
+	lambda.Start(service.invoke)
 }
 
-func run(ctx context.Context, req api.Input) (*tokenResponse, error) {
+func (s *Service) serve(ctx context.Context, req api.Input, caller api.Caller) (any, error) {
+
+	ctx, cancel := contextWithDeadlineMargin(ctx)
+	defer cancel()
 
-	secretsPrefix := os.Getenv("SECRETS_PREFIX")
-	secretsStorage := os.Getenv("SECRETS_STORAGE")
+	ctx = contextWithLoggerFields(ctx, req, caller)
+	s.logInitialRequest(ctx, req, caller)
 
-	if secretsStorage != api.SecretsStorageParameterStore && secretsStorage != api.SecretsStorageSecretsManager {
-		slog.ErrorContext(ctx, fmt.Sprintf("Unknown SECRETS_STORAGE %q", secretsStorage))
-		return nil, createErrorResponse("Error", 500)
+	if req.TokenContext.Endpoint.Type == api.EndpointTypeRevoke {
+		return s.handleRevoke(ctx, req)
 	}
 
-	if !prefixRegex.MatchString(secretsPrefix) {
-		slog.ErrorContext(ctx, fmt.Sprintf("Invalid SECRETS_PREFIX %q", secretsPrefix))
-		return nil, createErrorResponse("Error", 500)
+	return s.handleInput(ctx, req)
+}
+
+func (s *Service) handleInput(ctx context.Context, req api.Input) (*api.TokenResponse, error) {
+
+	owner, repos, err := s.validateInput(ctx, req)
+
+	if err != nil {
+		return nil, err
 	}
 
-	ctx = contextWithLoggerFields(ctx, req)
-	logInitialRequest(ctx, req)
+	if err := s.policy.check(req.TokenContext.ProviderName, req.TokenContext.Permissions); err != nil {
+		s.logger.InfoContext(ctx, fmt.Sprintf("PolicyViolation - %s", err.Error()))
+		return nil, createErrorResponse(api.ErrorCodePermissionsNotAllowed, "Requested permissions are not allowed", 403)
+	}
 
-	return handleInput(ctx, req, secretsStorage, secretsPrefix)
+	return s.handle(ctx, req, owner, repos)
 }
 
-func handleInput(ctx context.Context, req api.Input, secretsStorage string, secretsPrefix string) (*tokenResponse, error) {
+// validateInput checks the TokenContext and reads the owner and repositories of the TokenRequest, without
+// calling GitHub.
+func (s *Service) validateInput(ctx context.Context, req api.Input) (string, []string, error) {
 
 	if ok, err := isRepositorySelectionMode(req.TokenContext.TargetRule.RepositorySelectionMode); !ok {
-		slog.ErrorContext(ctx, err.Error())
-		return nil, createErrorResponse("Error", 500)
+		s.logger.ErrorContext(ctx, err.Error())
+		return "", nil, createErrorResponse(api.ErrorCodeConfiguration, "Error", 500)
 	}
 
 	if ok, err := isEndpointType(req.TokenContext.Endpoint.Type); !ok {
-		slog.ErrorContext(ctx, err.Error())
-		return nil, createErrorResponse("Error", 500)
+		s.logger.ErrorContext(ctx, err.Error())
+		return "", nil, createErrorResponse(api.ErrorCodeConfiguration, "Error", 500)
+	}
+
+	if err := checkPropertyFilters(req.TokenContext.TargetRule); err != nil {
+		s.logger.ErrorContext(ctx, err.Error())
+		return "", nil, createErrorResponse(api.ErrorCodeConfiguration, "Error", 500)
+	}
+
+	if err := checkPatterns(req.TokenContext); err != nil {
+		s.logger.ErrorContext(ctx, err.Error())
+		return "", nil, createErrorResponse(api.ErrorCodeConfiguration, "Error", 500)
 	}
 
 	owner := req.TokenRequest.Owner
 
 	if ok := readOwner(owner); ok == false {
-		slog.InfoContext(ctx, fmt.Sprintf("InputError - Value of provided owner (%q) is invalid", owner))
-		return nil, createErrorResponse("Value of provided owner is invalid", 400)
+		s.logger.InfoContext(ctx, fmt.Sprintf("InputError - Value of provided owner (%q) is invalid", owner))
+		return "", nil, createErrorResponse(api.ErrorCodeInvalidOwner, "Value of provided owner is invalid", 400)
 	}
 
-	repos, err := readRepo(req.TokenContext.Endpoint.Type, req.TokenContext.TargetRule.RepositorySelectionMode, req.TokenRequest.Repo)
-
-	if err != nil {
-		slog.InfoContext(ctx, fmt.Sprintf("InputError - repositories under selection mode %s", req.TokenContext.TargetRule.RepositorySelectionMode))
-		return nil, createErrorResponse("Invalid repository selection.", 400)
+	if ok, _ := isOwnerAllowed(req.TokenContext.AllowedOwners, owner); !ok {
+		s.logger.InfoContext(ctx, fmt.Sprintf("InputError - Owner %q is not allowed by provider %q", owner, req.TokenContext.ProviderName))
+		return "", nil, createErrorResponse(api.ErrorCodeOwnerNotAllowed, "Owner is not allowed.", 403)
 	}
 
-	return handle(ctx, req, secretsStorage, secretsPrefix, owner, repos)
-}
+	repos, err := readRepo(ctx, s.logger, req.TokenContext.Endpoint.Type, req.TokenContext.TargetRule, req.TokenRequest.Repo)
 
-func handle(ctx context.Context, req api.Input, secretsStorage string, secretsPrefix string, owner string, repos []string) (*tokenResponse, error) {
+	var notAllowedError *repositoryNotAllowedError
 
-	privateKey, err := getPrivateKey(ctx, secretsStorage, secretsPrefix, req.TokenContext.App.Name)
+	if errors.As(err, &notAllowedError) {
+		s.logger.InfoContext(ctx, fmt.Sprintf("InputError - %s", err.Error()))
+		return "", nil, createErrorResponse(api.ErrorCodeRepositoryNotAllowed, "Repository selection is not allowed.", 403)
+	} else if err != nil {
+		s.logger.InfoContext(ctx, fmt.Sprintf("InputError - repositories under selection mode %s", req.TokenContext.TargetRule.RepositorySelectionMode))
+		return "", nil, createErrorResponse(api.ErrorCodeInvalidRepositorySelection, "Invalid repository selection.", 400)
+	}
 
-	if err != nil {
-		slog.ErrorContext(ctx, fmt.Sprintf("PrivateKeyError - %s", err.Error()))
-		return nil, createErrorResponse("Error", 500)
+	if _, err := readSelector(req.TokenContext.TargetRule.RepositorySelectionMode, req.TokenRequest); err != nil {
+		s.logger.InfoContext(ctx, fmt.Sprintf("InputError - %s", err.Error()))
+		return "", nil, createErrorResponse(api.ErrorCodeInvalidRepositorySelection, "Invalid repository selection.", 400)
 	}
 
-	client, err := createClient(privateKey, req.TokenContext.App.Id)
+	return owner, repos, nil
+}
 
-	if err != nil {
-		slog.ErrorContext(ctx, fmt.Sprintf("GitHubClientError - %s", err.Error()))
-		return nil, createErrorResponse("Error", 500)
-	}
+func (s *Service) handle(ctx context.Context, req api.Input, owner string, repos []string) (*api.TokenResponse, error) {
 
-	installationId, err := findInstallation(ctx, client, req.TokenContext.App.Id, owner)
+	baseUrl, err := s.githubBaseUrl(req.TokenContext.App)
 
 	if err != nil {
-		slog.ErrorContext(ctx, fmt.Sprintf("InstallationNotFound - Could not find installation for %s", owner))
-		return nil, createErrorResponse("Error", 500)
+		s.logger.ErrorContext(ctx, fmt.Sprintf("ConfigurationError - %s", err.Error()))
+		return nil, createErrorResponse(api.ErrorCodeConfiguration, "Error", 500)
 	}
 
-	token, err := getToken(ctx, client, installationId, req.TokenContext.Permissions, repos)
+	appKeys, err := s.appTransports.get(ctx, req.TokenContext.App, baseUrl)
 
 	if err != nil {
-		slog.ErrorContext(ctx, fmt.Sprintf("TokenError - %s", err.Error()))
-		return nil, createErrorResponse("Error", 500)
+		s.logger.ErrorContext(ctx, fmt.Sprintf("PrivateKeyError - %s", err.Error()))
+		return nil, createErrorResponse(api.ErrorCodePrivateKey, "Error", 500)
 	}
 
-	slog.InfoContext(ctx, "TokenCreated")
+	var token *installationToken
+	var key appKey
+	var selected []string
 
-	return &tokenResponse{Token: *token.Token}, nil
-}
+	for i := range appKeys {
 
-func createErrorResponse(message string, statusCode int) error {
+		key = appKeys[i]
+		selected, err = s.selectRepositories(ctx, key.client, req, owner, repos)
 
-	e := errorResponse{SelectionPattern: fmt.Sprintf("CK_ERR_%v", statusCode), Message: message}
+		if err == nil {
+			token, err = s.getToken(ctx, key.client, req.TokenContext.App.Id, owner, req.TokenContext.Permissions, selected)
+		}
 
-	str, err := json.Marshal(e)
+		if !isUnauthorized(err) {
+			break
+		}
 
-	if err != nil {
-		panic(err)
+		s.logger.WarnContext(ctx, fmt.Sprintf("PrivateKeyRejected - GitHub rejected private key %s of %s", key.id, req.TokenContext.App.Name))
+		s.appTransports.reject(req.TokenContext.App.Name, key.id)
 	}
 
-	return errors.New(string(str))
-}
+	if isUnauthorized(err) {
+		s.logger.ErrorContext(ctx, fmt.Sprintf("PrivateKeyError - GitHub rejected all private keys of %s", req.TokenContext.App.Name))
+		s.appTransports.invalidate(req.TokenContext.App.Name)
+		return nil, createErrorResponse(api.ErrorCodePrivateKey, "Error", 500)
+	} else if e, ok := selectionErrorResponse(err); ok {
+		s.logger.InfoContext(ctx, fmt.Sprintf("SelectionError - %s", err.Error()))
+		return nil, e
+	} else if err != nil {
+		e := classifyGitHubError(err)
+		s.logErrorResponse(ctx, e, fmt.Sprintf("TokenError - %s", err.Error()))
+		return nil, e
+	}
 
-type tokenResponse struct {
-	Token string `json:"token"`
-}
+	attrs := []any{slog.String("privateKeyId", key.id), slog.String("privateKeyVersion", key.version)}
+
+	if len(selected) > 0 {
+		attrs = append(attrs, slog.Any("repositoryIds", repositoryIds(token)))
+	}
+
+	s.logger.InfoContext(ctx, "TokenCreated", attrs...)
 
-type errorResponse struct {
-	SelectionPattern string `json:"selectionPattern"`
-	Message          string `json:"message"`
+	return newTokenResponse(token), nil
 }
@@ -5,9 +5,13 @@ import (
 	"fmt"
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/catnekaise/ghrawel-tokenprovider-lambda-go/pkg/api"
+	"github.com/catnekaise/ghrawel-tokenprovider-lambda-go/pkg/fakegithub"
 	"github.com/google/go-github/v60/github"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"regexp"
+	"strings"
 	"testing"
 )
 
@@ -18,7 +22,7 @@ func Test_handleInput(t *testing.T) {
 	tests := []struct {
 		name       string
 		args       args
-		want       *tokenResponse
+		want       *api.TokenResponse
 		wantErr    bool
 		wantErrInt int
 	}{
@@ -89,7 +93,7 @@ func Test_handleInput(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := handleInput(context.TODO(), tt.args.req, "PARAMETER_STORE", "/")
+			got, err := newTestService(t, Options{SecretsStorage: "PARAMETER_STORE", SecretsPrefix: "/"}).handleInput(context.TODO(), tt.args.req)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("handleInput() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -106,53 +110,36 @@ func Test_handleInput(t *testing.T) {
 	}
 }
 
-func Test_run(t *testing.T) {
+func TestService_Handle(t *testing.T) {
 
-	t.Run("Secrets Storage", func(t *testing.T) {
+	t.Run("Revoke Invalid Token", func(t *testing.T) {
+		service := newTestService(t, Options{SecretsStorage: "PARAMETER_STORE", SecretsPrefix: "/"})
 
-		t.Setenv("SECRETS_PREFIX", "/")
-		t.Setenv("SECRETS_STORAGE", "S3")
+		req := createTestInput("catnekaise", nil, github.String("REVOKE"), nil)
+		req.TokenRequest.Token = github.String("ghp_personal")
 
-		_, err := run(context.TODO(), createTestInput("catnekaise", github.String("repo-1"), nil, nil))
+		_, err := service.Handle(context.TODO(), req)
 
 		if err == nil {
-			t.Error("run() did not return error as expected")
+			t.Error("Handle() did not return error as expected")
 		}
 
-		if !regexp.MustCompile("CK_ERR_500").MatchString(err.Error()) {
-			t.Errorf("run() got = %v, want %v", err.Error(), "CK_ERR_500")
-		}
-	})
-
-	t.Run("Secrets Prefix", func(t *testing.T) {
-		t.Setenv("SECRETS_PREFIX", "#/")
-		t.Setenv("SECRETS_STORAGE", "PARAMETER_STORE")
-
-		_, err := run(context.TODO(), createTestInput("catnekaise", github.String("repo-1"), nil, nil))
-
-		if err == nil {
-			t.Error("run() did not return error as expected")
-		}
-
-		fmt.Println(err.Error())
-
-		if !regexp.MustCompile("CK_ERR_500").MatchString(err.Error()) {
-			t.Errorf("run() got = %v, want %v", err.Error(), "CK_ERR_500")
+		if !regexp.MustCompile("CK_ERR_400").MatchString(err.Error()) {
+			t.Errorf("Handle() got = %v, want %v", err.Error(), "CK_ERR_400")
 		}
 	})
 
 	t.Run("Bad Repo", func(t *testing.T) {
-		t.Setenv("SECRETS_PREFIX", "/")
-		t.Setenv("SECRETS_STORAGE", "SECRETS_MANAGER")
+		service := newTestService(t, Options{SecretsStorage: "SECRETS_MANAGER", SecretsPrefix: "/"})
 
-		_, err := run(context.TODO(), createTestInput("catnekaise", github.String("repo-##1"), nil, nil))
+		_, err := service.Handle(context.TODO(), createTestInput("catnekaise", github.String("repo-##1"), nil, nil))
 
 		if err == nil {
-			t.Error("run() did not return error as expected")
+			t.Error("Handle() did not return error as expected")
 		}
 
 		if !regexp.MustCompile("CK_ERR_400").MatchString(err.Error()) {
-			t.Errorf("run() got = %v, want %v", err.Error(), "CK_ERR_400")
+			t.Errorf("Handle() got = %v, want %v", err.Error(), "CK_ERR_400")
 		}
 	})
 }
@@ -237,3 +224,247 @@ func createTestInput(owner string, repo *string, endpointType *string, repositor
 		},
 	}
 }
+
+func Test_handle_enterpriseServer(t *testing.T) {
+
+	t.Setenv("GITHUB_APP_PRIVATE_KEY_DEFAULT", createTestPrivateKey(t))
+
+	server := createTestEnterpriseServer(t)
+	service := newTestService(t, Options{AllowedBaseUrls: []string{server.URL}})
+
+	req := createTestInput("catnekaise", github.String("example-repo"), nil, nil)
+	req.TokenContext.App.BaseUrl = server.URL
+
+	got, err := service.handleInput(context.TODO(), req)
+
+	if err != nil {
+		t.Fatalf("handleInput() error = %v", err)
+	}
+
+	want := &api.TokenResponse{Token: "ghs_example", RepositorySelection: "selected"}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("handleInput() got = %v, want %v", got, want)
+	}
+
+	t.Run("base URL not allowed", func(t *testing.T) {
+
+		_, err := newTestService(t, Options{}).handleInput(context.TODO(), req)
+
+		if err == nil || !regexp.MustCompile("CK_ERR_500").MatchString(err.Error()) {
+			t.Errorf("handleInput() error = %v, want %v", err, "CK_ERR_500")
+		}
+	})
+
+	t.Run("revoke", func(t *testing.T) {
+
+		service := newTestService(t, Options{BaseUrl: server.URL})
+
+		revokeReq := createTestInput("catnekaise", nil, github.String("REVOKE"), nil)
+		revokeReq.TokenRequest.Token = github.String("ghs_example")
+
+		got, err := service.handleRevoke(context.TODO(), revokeReq)
+
+		if err != nil {
+			t.Fatalf("handleRevoke() error = %v", err)
+		}
+
+		if !got.Revoked {
+			t.Errorf("handleRevoke() got = %v, want %v", got.Revoked, true)
+		}
+
+		revokeReq.TokenRequest.Token = github.String("ghs_revoked")
+
+		_, err = service.handleRevoke(context.TODO(), revokeReq)
+
+		if err == nil || !regexp.MustCompile("CK_ERR_410").MatchString(err.Error()) {
+			t.Errorf("handleRevoke() error = %v, want %v", err, "CK_ERR_410")
+		}
+	})
+}
+
+func Test_handle_fakeGitHub(t *testing.T) {
+
+	server := fakegithub.New(t, 1234,
+		fakegithub.Installation{
+			Id:           1,
+			Owner:        "catnekaise",
+			Permissions:  map[string]string{"contents": "write", "metadata": "read"},
+			Repositories: []fakegithub.Repository{{Id: 10, Name: "example-repo"}, {Id: 11, Name: "other-repo"}, {Id: 12, Name: "10"}},
+			Selected:     []string{"example-repo", "10"},
+		},
+		fakegithub.Installation{
+			Id:           2,
+			Owner:        "djonser",
+			User:         true,
+			Permissions:  map[string]string{"contents": "read"},
+			Repositories: []fakegithub.Repository{{Id: 20, Name: "dotfiles"}},
+		},
+	)
+
+	other := fakegithub.New(t, 1234)
+
+	t.Setenv("GITHUB_APP_PRIVATE_KEY_DEFAULT", string(server.PrivateKey()))
+	t.Setenv("GITHUB_APP_PRIVATE_KEY_OTHER", string(other.PrivateKey()))
+
+	service := newTestService(t, Options{AllowedBaseUrls: []string{server.URL}})
+
+	tests := []struct {
+		name           string
+		req            api.Input
+		want           *api.TokenResponse
+		wantErrPattern string
+	}{
+		{
+			name: "organization repository",
+			req:  createTestInput("catnekaise", github.String("example-repo"), nil, nil),
+			want: &api.TokenResponse{
+				Permissions:         &api.Permissions{Contents: github.String("read")},
+				RepositorySelection: "selected",
+				Repositories:        []api.Repository{{Id: 10, Name: "example-repo", FullName: "catnekaise/example-repo"}},
+			},
+		},
+		{
+			name: "organization repository by id",
+			req:  createTestInput("catnekaise", github.String("id:10"), nil, nil),
+			want: &api.TokenResponse{
+				Permissions:         &api.Permissions{Contents: github.String("read")},
+				RepositorySelection: "selected",
+				Repositories:        []api.Repository{{Id: 10, Name: "example-repo", FullName: "catnekaise/example-repo"}},
+			},
+		},
+		{
+			name: "numeric repository name",
+			req:  createTestInput("catnekaise", github.String("10"), nil, nil),
+			want: &api.TokenResponse{
+				Permissions:         &api.Permissions{Contents: github.String("read")},
+				RepositorySelection: "selected",
+				Repositories:        []api.Repository{{Id: 12, Name: "10", FullName: "catnekaise/10"}},
+			},
+		},
+		{
+			name:           "repository id not selected",
+			req:            createTestInput("catnekaise", github.String("id:11"), nil, nil),
+			wantErrPattern: `"code":"REPOSITORY_NOT_ACCESSIBLE"`,
+		},
+		{
+			name: "all repositories of user",
+			req:  createTestInput("djonser", nil, github.String("DYNAMIC_OWNER"), github.String("ALLOW_OWNER")),
+			want: &api.TokenResponse{
+				Permissions:         &api.Permissions{Contents: github.String("read")},
+				RepositorySelection: "all",
+				Repositories:        []api.Repository{{Id: 20, Name: "dotfiles", FullName: "djonser/dotfiles"}},
+			},
+		},
+		{
+			name:           "not installed",
+			req:            createTestInput("unknown", github.String("example-repo"), nil, nil),
+			wantErrPattern: `"code":"INSTALLATION_NOT_FOUND"`,
+		},
+		{
+			name:           "repository not selected",
+			req:            createTestInput("catnekaise", github.String("other-repo"), nil, nil),
+			wantErrPattern: `"code":"REPOSITORY_NOT_ACCESSIBLE"`,
+		},
+		{
+			name: "permission not granted",
+			req: func() api.Input {
+				req := createTestInput("catnekaise", github.String("example-repo"), nil, nil)
+				req.TokenContext.Permissions = api.Permissions{Issues: github.String("write")}
+				return req
+			}(),
+			wantErrPattern: `"code":"PERMISSIONS_NOT_GRANTED"`,
+		},
+		{
+			name: "private key of other app",
+			req: func() api.Input {
+				req := createTestInput("catnekaise", github.String("example-repo"), nil, nil)
+				req.TokenContext.App.Name = "other"
+				return req
+			}(),
+			wantErrPattern: `"code":"PRIVATE_KEY_ERROR"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.req.TokenContext.App.BaseUrl = server.URL
+
+			got, err := service.handleInput(context.TODO(), tt.req)
+
+			if tt.wantErrPattern != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErrPattern) {
+					t.Errorf("handleInput() error = %v, want %v", err, tt.wantErrPattern)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("handleInput() error = %v", err)
+			}
+
+			if !strings.HasPrefix(got.Token, "ghs_") || got.ExpiresAt == nil {
+				t.Errorf("handleInput() got token = %v expiring %v", got.Token, got.ExpiresAt)
+			}
+
+			got.Token, got.ExpiresAt = "", nil
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("handleInput() got = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// createTestEnterpriseServer emulates the endpoints of a GitHub Enterprise Server used to create and revoke
+// tokens for the owner catnekaise.
+func createTestEnterpriseServer(t *testing.T) *httptest.Server {
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/api/v3/orgs/catnekaise/installation", func(w http.ResponseWriter, r *http.Request) {
+
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		fmt.Fprint(w, `{"id": 1}`)
+	})
+
+	mux.HandleFunc("/api/v3/app/installations/1/access_tokens", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, `{"token": "ghs_example", "repository_selection": "selected"}`)
+	})
+
+	mux.HandleFunc("/api/v3/installation/token", func(w http.ResponseWriter, r *http.Request) {
+
+		if r.Method != http.MethodDelete || r.Header.Get("Authorization") != "Bearer ghs_example" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+// newTestService creates a service reading private keys from GITHUB_APP_PRIVATE_KEY_<NAME> variables unless
+// another storage is set.
+func newTestService(t *testing.T, opts Options) *Service {
+
+	if opts.KeyProvider == nil && opts.SecretsStorage == "" {
+		opts.SecretsStorage = api.SecretsStorageEnv
+	}
+
+	service, err := NewService(context.TODO(), opts)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return service
+}
@@ -8,9 +8,10 @@ import (
 	"os"
 )
 
+// logger adds the fields of the request in the context to every record before passing it on to next.
 type logger struct {
-	minLevel    slog.Level
-	jsonHandler *slog.JSONHandler
+	minLevel slog.Level
+	next     slog.Handler
 }
 
 type extraFields struct {
@@ -26,24 +27,26 @@ type extraFields struct {
 
 type ctxKey struct{}
 
-func level() slog.Level {
+func handler(level slog.Level) *slog.JSONHandler {
 
-	if os.Getenv("DEBUG_LOGGING") == "true" {
-		return slog.LevelDebug
-	}
-
-	return slog.LevelInfo
+	return slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+		Level: level,
+	})
 }
 
-func handler() *slog.JSONHandler {
+// newContextLogger wraps the handler of l so that records get the fields of the request, like the records of
+// the default logger of the function.
+func newContextLogger(l *slog.Logger) *slog.Logger {
 
-	return slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
-		Level: level(),
-	})
+	if _, ok := l.Handler().(logger); ok {
+		return l
+	}
+
+	return slog.New(logger{minLevel: slog.LevelDebug, next: l.Handler()})
 }
 
 func (m logger) Enabled(ctx context.Context, level slog.Level) bool {
-	return level >= m.minLevel
+	return level >= m.minLevel && m.next.Enabled(ctx, level)
 }
 
 func (m logger) Handle(ctx context.Context, record slog.Record) error {
@@ -51,7 +54,7 @@ func (m logger) Handle(ctx context.Context, record slog.Record) error {
 	xfValue := ctx.Value(ctxKey{})
 
 	if xfValue == nil {
-		return m.jsonHandler.Handle(ctx, record)
+		return m.next.Handle(ctx, record)
 	}
 
 	fields := xfValue.(extraFields)
@@ -92,24 +95,24 @@ func (m logger) Handle(ctx context.Context, record slog.Record) error {
 
 	}
 
-	return m.jsonHandler.WithAttrs(attrs).Handle(ctx, record)
+	return m.next.WithAttrs(attrs).Handle(ctx, record)
 }
 
 func (m logger) WithAttrs(attrs []slog.Attr) slog.Handler {
-	return m.jsonHandler.WithAttrs(attrs)
+	return logger{minLevel: m.minLevel, next: m.next.WithAttrs(attrs)}
 }
 
 func (m logger) WithGroup(name string) slog.Handler {
-	return m.jsonHandler.WithGroup(name)
+	return logger{minLevel: m.minLevel, next: m.next.WithGroup(name)}
 }
 
-func contextWithLoggerFields(ctx context.Context, req api.Input) context.Context {
+func contextWithLoggerFields(ctx context.Context, req api.Input, caller api.Caller) context.Context {
 
 	fields := extraFields{
-		RequestId:         req.RequestContext.RequestID,
+		RequestId:         caller.RequestId,
 		TokenProviderName: req.TokenContext.ProviderName,
-		UserArn:           req.RequestContext.Identity.UserArn,
-		User:              req.RequestContext.Identity.User,
+		UserArn:           caller.UserArn,
+		User:              caller.User,
 		TokenRequestOwner: req.TokenRequest.Owner,
 		TokenRequestRepo:  req.TokenRequest.Repo,
 		GithubAppId:       req.TokenContext.App.Id,
@@ -122,12 +125,12 @@ func contextWithLoggerFields(ctx context.Context, req api.Input) context.Context
 	return context.WithValue(ctx, ctxKey{}, fields)
 }
 
-func logInitialRequest(ctx context.Context, req api.Input) {
+func (s *Service) logInitialRequest(ctx context.Context, req api.Input, caller api.Caller) {
 
 	attrs := []slog.Attr{
 		{
 			Key:   "path",
-			Value: slog.StringValue(req.RequestContext.Path),
+			Value: slog.StringValue(caller.Path),
 		},
 		{
 			Key:   "endpointType",
@@ -143,7 +146,7 @@ func logInitialRequest(ctx context.Context, req api.Input) {
 		},
 		{
 			Key:   "userAgent",
-			Value: slog.StringValue(req.RequestContext.Identity.UserAgent),
+			Value: slog.StringValue(caller.UserAgent),
 		},
 		{
 			Key:   "permissions",
@@ -151,12 +154,20 @@ func logInitialRequest(ctx context.Context, req api.Input) {
 		},
 	}
 
-	if req.RequestContext.Identity.CognitoIdentityPoolID != "" {
-		attrs = append(attrs, slog.Attr{Key: "cognitoIdentityPoolID", Value: slog.StringValue(req.RequestContext.Identity.CognitoIdentityPoolID)})
-		attrs = append(attrs, slog.Attr{Key: "cognitoIdentityID", Value: slog.StringValue(req.RequestContext.Identity.CognitoIdentityID)})
-		attrs = append(attrs, slog.Attr{Key: "cognitoAuthenticationProvider", Value: slog.StringValue(req.RequestContext.Identity.CognitoAuthenticationProvider)})
-		attrs = append(attrs, slog.Attr{Key: "cognitoAuthenticationType", Value: slog.StringValue(req.RequestContext.Identity.CognitoAuthenticationType)})
+	if caller.AccountId != "" {
+		attrs = append(attrs, slog.Attr{Key: "accountId", Value: slog.StringValue(caller.AccountId)})
+	}
+
+	if caller.SourceIp != "" {
+		attrs = append(attrs, slog.Attr{Key: "sourceIp", Value: slog.StringValue(caller.SourceIp)})
+	}
+
+	if caller.CognitoIdentityPoolId != "" {
+		attrs = append(attrs, slog.Attr{Key: "cognitoIdentityPoolID", Value: slog.StringValue(caller.CognitoIdentityPoolId)})
+		attrs = append(attrs, slog.Attr{Key: "cognitoIdentityID", Value: slog.StringValue(caller.CognitoIdentityId)})
+		attrs = append(attrs, slog.Attr{Key: "cognitoAuthenticationProvider", Value: slog.StringValue(caller.CognitoAuthenticationProvider)})
+		attrs = append(attrs, slog.Attr{Key: "cognitoAuthenticationType", Value: slog.StringValue(caller.CognitoAuthenticationType)})
 	}
 
-	slog.LogAttrs(ctx, slog.LevelInfo, "Init", attrs...)
+	s.logger.LogAttrs(ctx, slog.LevelInfo, "Init", attrs...)
 }
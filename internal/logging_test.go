@@ -0,0 +1,38 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/catnekaise/ghrawel-tokenprovider-lambda-go/pkg/api"
+	"log/slog"
+	"testing"
+)
+
+func Test_logger_WithAttrs(t *testing.T) {
+
+	var buf bytes.Buffer
+
+	l := newContextLogger(slog.New(slog.NewJSONHandler(&buf, nil))).With("component", "test").WithGroup("details")
+
+	req := api.Input{TokenRequest: api.TokenRequest{Owner: "catnekaise"}}
+	ctx := contextWithLoggerFields(context.TODO(), req, api.Caller{RequestId: "request-1"})
+
+	l.InfoContext(ctx, "Message", "key", "value")
+
+	var record map[string]any
+
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatal(err)
+	}
+
+	if record["component"] != "test" {
+		t.Errorf("record component = %v, want test", record["component"])
+	}
+
+	details, _ := record["details"].(map[string]any)
+
+	if details["awsRequestId"] != "request-1" || details["key"] != "value" {
+		t.Errorf("record details = %v, want request fields and key", details)
+	}
+}
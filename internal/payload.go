@@ -0,0 +1,250 @@
+package internal
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/catnekaise/ghrawel-tokenprovider-lambda-go/pkg/api"
+	"strconv"
+	"strings"
+)
+
+// payloadProbe holds the fields telling the supported payloads apart. Only payloads of the HTTP API and of
+// Function URLs, which share the 2.0 format, have requestContext.http.
+// Direct invocations have neither requestContext nor tokenContext.
+type payloadProbe struct {
+	RequestContext *struct {
+		DomainName string           `json:"domainName"`
+		HTTP       *json.RawMessage `json:"http"`
+	} `json:"requestContext"`
+	TokenContext *json.RawMessage `json:"tokenContext"`
+}
+
+// httpRequest is a request received through an API Gateway HTTP API or a Lambda Function URL.
+type httpRequest struct {
+	caller         api.Caller
+	path           string
+	pathParameters map[string]string
+	query          map[string]string
+	// authorization is the Authorization header, which may carry the token to revoke.
+	authorization   string
+	body            string
+	isBase64Encoded bool
+}
+
+type httpResponse struct {
+	statusCode int
+	headers    map[string]string
+	body       string
+}
+
+// invoke is the handler of the function. It accepts the payload of the ghrawel REST API mapping template,
+// of an API Gateway HTTP API, of a Lambda Function URL and of a direct invocation.
+func (s *Service) invoke(ctx context.Context, payload json.RawMessage) (any, error) {
+
+	var probe payloadProbe
+
+	if err := json.Unmarshal(payload, &probe); err != nil {
+		s.logger.ErrorContext(ctx, fmt.Sprintf("InvalidPayload - %s", err.Error()))
+		return nil, createErrorResponse(api.ErrorCodeInvalidRequest, "Error", 400)
+	}
+
+	if probe.RequestContext == nil && probe.TokenContext == nil {
+
+		var req api.InvokeRequest
+
+		if err := json.Unmarshal(payload, &req); err != nil {
+			s.logger.ErrorContext(ctx, fmt.Sprintf("InvalidPayload - %s", err.Error()))
+			return newInvokeResponse(nil, createErrorResponse(api.ErrorCodeInvalidRequest, "Error", 400)), nil
+		}
+
+		return s.handleDirectInvoke(ctx, req), nil
+	}
+
+	if probe.RequestContext != nil && probe.RequestContext.HTTP != nil && strings.Contains(probe.RequestContext.DomainName, ".lambda-url.") {
+
+		var req events.LambdaFunctionURLRequest
+
+		if err := json.Unmarshal(payload, &req); err != nil {
+			s.logger.ErrorContext(ctx, fmt.Sprintf("InvalidPayload - %s", err.Error()))
+			return nil, createErrorResponse(api.ErrorCodeInvalidRequest, "Error", 400)
+		}
+
+		return s.handleFunctionUrl(ctx, req), nil
+	}
+
+	if probe.RequestContext != nil && probe.RequestContext.HTTP != nil {
+
+		var req events.APIGatewayV2HTTPRequest
+
+		if err := json.Unmarshal(payload, &req); err != nil {
+			s.logger.ErrorContext(ctx, fmt.Sprintf("InvalidPayload - %s", err.Error()))
+			return nil, createErrorResponse(api.ErrorCodeInvalidRequest, "Error", 400)
+		}
+
+		return s.handleHttpApi(ctx, req), nil
+	}
+
+	var req api.Input
+
+	if err := json.Unmarshal(payload, &req); err != nil {
+		s.logger.ErrorContext(ctx, fmt.Sprintf("InvalidPayload - %s", err.Error()))
+		return nil, createErrorResponse(api.ErrorCodeInvalidRequest, "Error", 400)
+	}
+
+	return s.Handle(ctx, req)
+}
+
+func (s *Service) handleHttpApi(ctx context.Context, req events.APIGatewayV2HTTPRequest) *events.APIGatewayV2HTTPResponse {
+
+	response := s.serveHttp(ctx, httpRequest{
+		caller:          httpApiCaller(req),
+		path:            req.RawPath,
+		pathParameters:  req.PathParameters,
+		query:           req.QueryStringParameters,
+		authorization:   req.Headers["authorization"],
+		body:            req.Body,
+		isBase64Encoded: req.IsBase64Encoded,
+	})
+
+	return &events.APIGatewayV2HTTPResponse{StatusCode: response.statusCode, Headers: response.headers, Body: response.body}
+}
+
+func (s *Service) handleFunctionUrl(ctx context.Context, req events.LambdaFunctionURLRequest) *events.LambdaFunctionURLResponse {
+
+	response := s.serveHttp(ctx, httpRequest{
+		caller:          functionUrlCaller(req),
+		path:            req.RawPath,
+		query:           req.QueryStringParameters,
+		authorization:   req.Headers["authorization"],
+		body:            req.Body,
+		isBase64Encoded: req.IsBase64Encoded,
+	})
+
+	return &events.LambdaFunctionURLResponse{StatusCode: response.statusCode, Headers: response.headers, Body: response.body}
+}
+
+// serveHttp serves requests of the HTTP API and of Function URLs, which have to be authorized with IAM.
+func (s *Service) serveHttp(ctx context.Context, req httpRequest) httpResponse {
+
+	if req.caller.UserArn == "" {
+		s.logger.InfoContext(ctx, "Unauthenticated - Request was not authorized with IAM")
+		return newHttpResponse(nil, createErrorResponse(api.ErrorCodeUnauthenticated, "Request must be signed with AWS credentials", 403))
+	}
+
+	return s.serveProviderRequest(ctx, req)
+}
+
+// serveProviderRequest resolves the provider from the {provider} path parameter, or the first segment of the
+// path, and reads the token request from the JSON body or the query string. A token to revoke is only read
+// from the JSON body or the Authorization header, never from the query string, since URLs end up in logs.
+func (s *Service) serveProviderRequest(ctx context.Context, req httpRequest) httpResponse {
+
+	name := req.pathParameters["provider"]
+
+	if name == "" {
+		name, _, _ = strings.Cut(strings.TrimPrefix(req.path, "/"), "/")
+	}
+
+	tokenRequest, err := readHttpTokenRequest(req)
+
+	if err != nil {
+		s.logger.InfoContext(ctx, fmt.Sprintf("InputError - %s", err.Error()))
+		return newHttpResponse(nil, createErrorResponse(api.ErrorCodeInvalidRequest, "Request body is invalid", 400))
+	}
+
+	input, e := s.providerInput(ctx, name, tokenRequest)
+
+	if e != nil {
+		return newHttpResponse(nil, e)
+	}
+
+	return newHttpResponse(s.serve(ctx, input, req.caller))
+}
+
+func readHttpTokenRequest(req httpRequest) (api.TokenRequest, error) {
+
+	var tokenRequest api.TokenRequest
+
+	body := req.body
+
+	if req.isBase64Encoded {
+
+		decoded, err := base64.StdEncoding.DecodeString(body)
+
+		if err != nil {
+			return tokenRequest, err
+		}
+
+		body = string(decoded)
+	}
+
+	if _, ok := req.query["token"]; ok {
+		return tokenRequest, errors.New("token cannot be passed in the query string")
+	}
+
+	if strings.TrimSpace(body) != "" {
+
+		if err := json.Unmarshal([]byte(body), &tokenRequest); err != nil {
+			return tokenRequest, err
+		}
+	} else {
+
+		tokenRequest.Owner = req.query["owner"]
+
+		if repo, ok := req.query["repo"]; ok {
+			tokenRequest.Repo = &repo
+		}
+	}
+
+	if token, ok := readAuthorizationToken(req.authorization); ok && tokenRequest.Token == nil {
+		tokenRequest.Token = &token
+	}
+
+	return tokenRequest, nil
+}
+
+// readAuthorizationToken reads the token of an Authorization header using the Bearer or token scheme. The
+// signature of a request signed with AWS credentials uses neither.
+func readAuthorizationToken(authorization string) (string, bool) {
+
+	scheme, token, ok := strings.Cut(strings.TrimSpace(authorization), " ")
+	token = strings.TrimSpace(token)
+
+	if !ok || token == "" || (!strings.EqualFold(scheme, "Bearer") && !strings.EqualFold(scheme, "token")) {
+		return "", false
+	}
+
+	return token, true
+}
+
+func newHttpResponse(result any, err error) httpResponse {
+
+	headers := map[string]string{"Content-Type": "application/json"}
+
+	var e *errorResponse
+
+	if err != nil && !errors.As(err, &e) {
+		e = createErrorResponse(api.ErrorCodeInternal, "Error", 500)
+	}
+
+	if e != nil {
+
+		if e.RetryAfter > 0 {
+			headers["Retry-After"] = strconv.Itoa(e.RetryAfter)
+		}
+
+		return httpResponse{statusCode: e.statusCode, headers: headers, body: e.Error()}
+	}
+
+	body, err := json.Marshal(result)
+
+	if err != nil {
+		return newHttpResponse(nil, err)
+	}
+
+	return httpResponse{statusCode: 200, headers: headers, body: string(body)}
+}
@@ -0,0 +1,179 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func Test_invoke(t *testing.T) {
+
+	t.Setenv("GITHUB_APP_PRIVATE_KEY_DEFAULT", createTestPrivateKey(t))
+
+	server := createTestEnterpriseServer(t)
+	providersFile := filepath.Join(t.TempDir(), "providers.json")
+
+	err := os.WriteFile(providersFile, []byte(fmt.Sprintf(`{
+		"providers": [
+			{
+				"providerName": "example",
+				"permissions": {"contents": "read"},
+				"app": {"id": 1234, "name": "default", "baseUrl": %q},
+				"endpoint": {"type": "STATIC_OWNER"},
+				"targetRule": {"repositorySelectionMode": "AT_LEAST_ONE"},
+				"owner": "catnekaise"
+			},
+			{
+				"providerName": "revoke",
+				"app": {"id": 1234, "name": "default", "baseUrl": %q},
+				"endpoint": {"type": "REVOKE"},
+				"targetRule": {"repositorySelectionMode": "ALLOW_OWNER"},
+				"owner": "catnekaise"
+			}
+		]
+	}`, server.URL, server.URL)), 0600)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := loadProviders(providersFile)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	service := newTestService(t, Options{Providers: loaded, AllowedBaseUrls: []string{server.URL}})
+
+	iam := `"authorizer": {"iam": {"userArn": "arn:aws:iam::123456789012:role/example", "userId": "AROAEXAMPLE", "accountId": "123456789012"}},`
+
+	tests := []struct {
+		name           string
+		payload        string
+		wantStatusCode int
+		wantBody       string
+	}{
+		{
+			name: "function url",
+			payload: `{"version": "2.0", "rawPath": "/example", "body": "{\"repo\": \"example-repo\"}",
+				"requestContext": {` + iam + ` "domainName": "abc.lambda-url.eu-west-1.on.aws", "http": {"method": "POST", "path": "/example"}}}`,
+			wantStatusCode: 200,
+			wantBody:       `"token":"ghs_example"`,
+		},
+		{
+			name: "http api",
+			payload: `{"version": "2.0", "rawPath": "/tokens/example", "pathParameters": {"provider": "example"}, "queryStringParameters": {"repo": "example-repo"},
+				"requestContext": {` + iam + ` "domainName": "abc.execute-api.eu-west-1.amazonaws.com", "http": {"method": "GET", "path": "/tokens/example"}}}`,
+			wantStatusCode: 200,
+			wantBody:       `"token":"ghs_example"`,
+		},
+		{
+			name: "revoke token in body",
+			payload: `{"version": "2.0", "rawPath": "/revoke", "body": "{\"token\": \"ghs_example\"}",
+				"requestContext": {` + iam + ` "domainName": "abc.lambda-url.eu-west-1.on.aws", "http": {"method": "POST", "path": "/revoke"}}}`,
+			wantStatusCode: 200,
+			wantBody:       `"revoked":true`,
+		},
+		{
+			name: "revoke token in query string",
+			payload: `{"version": "2.0", "rawPath": "/revoke", "queryStringParameters": {"token": "ghs_example"},
+				"requestContext": {` + iam + ` "domainName": "abc.lambda-url.eu-west-1.on.aws", "http": {"method": "GET", "path": "/revoke"}}}`,
+			wantStatusCode: 400,
+			wantBody:       `"code":"INVALID_REQUEST"`,
+		},
+		{
+			name: "not authorized with iam",
+			payload: `{"version": "2.0", "rawPath": "/example",
+				"requestContext": {"domainName": "abc.lambda-url.eu-west-1.on.aws", "http": {"method": "POST", "path": "/example"}}}`,
+			wantStatusCode: 403,
+			wantBody:       `"code":"UNAUTHENTICATED"`,
+		},
+		{
+			name: "unknown provider",
+			payload: `{"version": "2.0", "rawPath": "/unknown",
+				"requestContext": {` + iam + ` "domainName": "abc.lambda-url.eu-west-1.on.aws", "http": {"method": "POST", "path": "/unknown"}}}`,
+			wantStatusCode: 404,
+			wantBody:       `"code":"PROVIDER_NOT_FOUND"`,
+		},
+		{
+			name: "other owner than static owner",
+			payload: `{"version": "2.0", "rawPath": "/example", "body": "{\"owner\": \"other\", \"repo\": \"example-repo\"}",
+				"requestContext": {` + iam + ` "domainName": "abc.lambda-url.eu-west-1.on.aws", "http": {"method": "POST", "path": "/example"}}}`,
+			wantStatusCode: 400,
+			wantBody:       `"code":"INVALID_OWNER"`,
+		},
+		{
+			name: "invalid repo",
+			payload: `{"version": "2.0", "rawPath": "/example", "body": "{\"repo\": \"example-repo#\"}",
+				"requestContext": {` + iam + ` "domainName": "abc.lambda-url.eu-west-1.on.aws", "http": {"method": "POST", "path": "/example"}}}`,
+			wantStatusCode: 400,
+			wantBody:       `"code":"INVALID_REPOSITORY_SELECTION"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := service.invoke(context.TODO(), json.RawMessage(tt.payload))
+
+			if err != nil {
+				t.Fatalf("invoke() error = %v", err)
+			}
+
+			var statusCode int
+			var body string
+
+			switch response := got.(type) {
+			case *events.LambdaFunctionURLResponse:
+				statusCode, body = response.StatusCode, response.Body
+			case *events.APIGatewayV2HTTPResponse:
+				statusCode, body = response.StatusCode, response.Body
+			default:
+				t.Fatalf("invoke() got = %T", got)
+			}
+
+			if statusCode != tt.wantStatusCode || !strings.Contains(body, tt.wantBody) {
+				t.Errorf("invoke() got = %v %v, want %v %v", statusCode, body, tt.wantStatusCode, tt.wantBody)
+			}
+		})
+	}
+
+	t.Run("rest api", func(t *testing.T) {
+
+		payload, _ := json.Marshal(createTestInput("catnekaise#", nil, nil, nil))
+
+		_, err := service.invoke(context.TODO(), payload)
+
+		if err == nil || !regexp.MustCompile("CK_ERR_400").MatchString(err.Error()) {
+			t.Errorf("invoke() error = %v, want %v", err, "CK_ERR_400")
+		}
+	})
+}
+
+func Test_readAuthorizationToken(t *testing.T) {
+
+	tests := []struct {
+		authorization string
+		want          string
+		wantOk        bool
+	}{
+		{authorization: "Bearer ghs_example", want: "ghs_example", wantOk: true},
+		{authorization: "token ghs_example", want: "ghs_example", wantOk: true},
+		{authorization: "AWS4-HMAC-SHA256 Credential=AKIAEXAMPLE/20240601/eu-west-1/lambda/aws4_request, SignedHeaders=host, Signature=abc"},
+		{authorization: "Bearer "},
+		{authorization: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.authorization, func(t *testing.T) {
+			got, ok := readAuthorizationToken(tt.authorization)
+
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("readAuthorizationToken() got = %q, %v, want %q, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
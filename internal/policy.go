@@ -0,0 +1,188 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/catnekaise/ghrawel-tokenprovider-lambda-go/pkg/api"
+	"os"
+	"sort"
+)
+
+const anyProvider = "*"
+
+var accessLevels = map[string]int{"read": 1, "write": 2, "admin": 3}
+
+type permissionPolicyParameterAPI interface {
+	GetParameter(ctx context.Context, params *ssm.GetParameterInput, optFns ...func(*ssm.Options)) (*ssm.GetParameterOutput, error)
+}
+
+// permissionPolicy holds the policies of a PermissionPolicy by provider name.
+type permissionPolicy map[string][]api.ProviderPermissionPolicy
+
+func newPermissionPolicy(policy *api.PermissionPolicy) (permissionPolicy, error) {
+
+	if policy == nil {
+		return nil, nil
+	}
+
+	result := permissionPolicy{}
+
+	for _, provider := range policy.Providers {
+
+		if provider.ProviderName == "" {
+			return nil, errors.New("permission policy without providerName")
+		}
+
+		for _, permissions := range []*api.Permissions{provider.MaxPermissions, provider.ForbiddenPermissions} {
+			for name, access := range permissionsMap(permissions) {
+				if _, ok := accessLevels[access]; !ok {
+					return nil, errors.New(fmt.Sprintf("permission policy of %s has unknown access %q for %s", provider.ProviderName, access, name))
+				}
+			}
+		}
+
+		result[provider.ProviderName] = append(result[provider.ProviderName], provider)
+	}
+
+	return result, nil
+}
+
+// check returns why the permissions may not be requested from the provider, or nil when they may.
+func (p permissionPolicy) check(providerName string, permissions api.Permissions) error {
+
+	requested := permissionsMap(&permissions)
+	policies := append(append([]api.ProviderPermissionPolicy{}, p[anyProvider]...), p[providerName]...)
+
+	// a token without permissions has every permission of the installation, forbidden ones included
+	if len(policies) > 0 && len(requested) == 0 {
+		return errors.New("permissions have to be requested explicitly")
+	}
+
+	for _, policy := range policies {
+
+		if policy.MaxPermissions != nil {
+
+			allowed := permissionsMap(policy.MaxPermissions)
+
+			for _, name := range sortedKeys(requested) {
+
+				if _, ok := allowed[name]; !ok {
+					return errors.New(fmt.Sprintf("%s is not allowed", name))
+				}
+
+				if accessLevel(requested[name]) > accessLevel(allowed[name]) {
+					return errors.New(fmt.Sprintf("%s:%s exceeds %s:%s", name, requested[name], name, allowed[name]))
+				}
+			}
+		}
+
+		forbidden := permissionsMap(policy.ForbiddenPermissions)
+
+		for _, name := range sortedKeys(requested) {
+
+			if access, ok := forbidden[name]; ok && accessLevel(requested[name]) >= accessLevel(access) {
+				return errors.New(fmt.Sprintf("%s:%s is forbidden", name, requested[name]))
+			}
+		}
+	}
+
+	return nil
+}
+
+// accessLevel orders access levels, where unknown levels rank above admin.
+func accessLevel(access string) int {
+
+	if level, ok := accessLevels[access]; ok {
+		return level
+	}
+
+	return len(accessLevels) + 1
+}
+
+// permissionsMap returns the requested permissions by their JSON name.
+func permissionsMap(permissions *api.Permissions) map[string]string {
+
+	values := map[string]string{}
+
+	if permissions == nil {
+		return values
+	}
+
+	data, err := json.Marshal(permissions)
+
+	if err != nil {
+		return values
+	}
+
+	_ = json.Unmarshal(data, &values)
+
+	return values
+}
+
+func sortedKeys(values map[string]string) []string {
+
+	names := make([]string, 0, len(values))
+
+	for name := range values {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+func loadPermissionPolicyFile(name string) (*api.PermissionPolicy, error) {
+
+	data, err := os.ReadFile(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return parsePermissionPolicy(name, data)
+}
+
+// loadPermissionPolicyParameter reads the policy from a parameter of Parameter Store, using a client created
+// from the default AWS configuration when client is nil.
+func loadPermissionPolicyParameter(ctx context.Context, client permissionPolicyParameterAPI, name string) (*api.PermissionPolicy, error) {
+
+	if client == nil {
+		cfg, err := config.LoadDefaultConfig(ctx)
+		if err != nil {
+			return nil, err
+		}
+		client = ssm.NewFromConfig(cfg)
+	}
+
+	parameter, err := client.GetParameter(ctx, &ssm.GetParameterInput{
+		Name:           aws.String(name),
+		WithDecryption: aws.Bool(true),
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return parsePermissionPolicy(name, []byte(aws.ToString(parameter.Parameter.Value)))
+}
+
+func parsePermissionPolicy(name string, data []byte) (*api.PermissionPolicy, error) {
+
+	var policy api.PermissionPolicy
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(&policy); err != nil {
+		return nil, errors.New(fmt.Sprintf("%s is not a valid permission policy: %s", name, err.Error()))
+	}
+
+	return &policy, nil
+}
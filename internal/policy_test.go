@@ -0,0 +1,138 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/catnekaise/ghrawel-tokenprovider-lambda-go/pkg/api"
+	"github.com/google/go-github/v60/github"
+	"regexp"
+	"testing"
+)
+
+func Test_permissionPolicy_check(t *testing.T) {
+
+	policy, err := newPermissionPolicy(&api.PermissionPolicy{
+		Providers: []api.ProviderPermissionPolicy{
+			{
+				ProviderName:         "*",
+				ForbiddenPermissions: &api.Permissions{Administration: github.String("write"), OrganizationAdministration: github.String("read")},
+			},
+			{
+				ProviderName:   "limited",
+				MaxPermissions: &api.Permissions{Contents: github.String("write"), Metadata: github.String("read")},
+			},
+		},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		providerName string
+		permissions  api.Permissions
+		wantErr      string
+	}{
+		{name: "within max", providerName: "limited", permissions: api.Permissions{Contents: github.String("read")}},
+		{name: "max exceeded", providerName: "limited", permissions: api.Permissions{Metadata: github.String("write")}, wantErr: "metadata:write exceeds metadata:read"},
+		{name: "not in max", providerName: "limited", permissions: api.Permissions{Issues: github.String("read")}, wantErr: "issues is not allowed"},
+		{name: "all permissions under max", providerName: "limited", permissions: api.Permissions{}, wantErr: "permissions have to be requested explicitly"},
+		{name: "all permissions without max", providerName: "other", permissions: api.Permissions{}, wantErr: "permissions have to be requested explicitly"},
+		{name: "below forbidden", providerName: "other", permissions: api.Permissions{Administration: github.String("read")}},
+		{name: "forbidden", providerName: "other", permissions: api.Permissions{Administration: github.String("write")}, wantErr: "administration:write is forbidden"},
+		{name: "forbidden entirely", providerName: "limited", permissions: api.Permissions{OrganizationAdministration: github.String("read")}, wantErr: "organization_administration:read is forbidden"},
+		{name: "unknown access", providerName: "other", permissions: api.Permissions{Administration: github.String("owner")}, wantErr: "administration:owner is forbidden"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.check(tt.providerName, tt.permissions)
+
+			if (err == nil && tt.wantErr != "") || (err != nil && err.Error() != tt.wantErr) {
+				t.Errorf("check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	t.Run("no policy", func(t *testing.T) {
+		var policy permissionPolicy
+
+		if err := policy.check("other", api.Permissions{Administration: github.String("admin")}); err != nil {
+			t.Errorf("check() error = %v", err)
+		}
+
+		if err := policy.check("other", api.Permissions{}); err != nil {
+			t.Errorf("check() error = %v", err)
+		}
+	})
+
+	t.Run("unknown access in policy", func(t *testing.T) {
+		_, err := newPermissionPolicy(&api.PermissionPolicy{Providers: []api.ProviderPermissionPolicy{
+			{ProviderName: "other", MaxPermissions: &api.Permissions{Contents: github.String("all")}},
+		}})
+
+		if err == nil {
+			t.Error("newPermissionPolicy() did not return error as expected")
+		}
+	})
+}
+
+func Test_handleInput_permissionPolicy(t *testing.T) {
+
+	service := newTestService(t, Options{
+		SecretsStorage: "PARAMETER_STORE",
+		SecretsPrefix:  "/",
+		PermissionPolicy: &api.PermissionPolicy{Providers: []api.ProviderPermissionPolicy{
+			{ProviderName: "test", ForbiddenPermissions: &api.Permissions{Contents: github.String("read")}},
+		}},
+	})
+
+	_, err := service.handleInput(context.TODO(), createTestInput("catnekaise", github.String("example-repo"), nil, nil))
+
+	if err == nil || !regexp.MustCompile(`CK_ERR_403.*PERMISSIONS_NOT_ALLOWED`).MatchString(err.Error()) {
+		t.Errorf("handleInput() error = %v, want %v", err, "CK_ERR_403")
+	}
+}
+
+type testParameterClient map[string]string
+
+func (c testParameterClient) GetParameter(ctx context.Context, params *ssm.GetParameterInput, optFns ...func(*ssm.Options)) (*ssm.GetParameterOutput, error) {
+
+	value, ok := c[aws.ToString(params.Name)]
+
+	if !ok {
+		return nil, errors.New("ParameterNotFound")
+	}
+
+	return &ssm.GetParameterOutput{Parameter: &types.Parameter{Value: aws.String(value)}}, nil
+}
+
+func Test_loadPermissionPolicyParameter(t *testing.T) {
+
+	client := testParameterClient{
+		"/policy":  `{"providers": [{"providerName": "*", "forbiddenPermissions": {"administration": "write"}}]}`,
+		"/invalid": `{"providers": {}}`,
+		"/typo":    `{"providers": [{"providerName": "*", "forbiddenPermissions": {"adminstration": "write"}}]}`,
+	}
+
+	got, err := loadPermissionPolicyParameter(context.TODO(), client, "/policy")
+
+	if err != nil {
+		t.Fatalf("loadPermissionPolicyParameter() error = %v", err)
+	}
+
+	if len(got.Providers) != 1 || *got.Providers[0].ForbiddenPermissions.Administration != "write" {
+		t.Errorf("loadPermissionPolicyParameter() got = %+v", got)
+	}
+
+	if _, err := loadPermissionPolicyParameter(context.TODO(), client, "/invalid"); err == nil {
+		t.Error("loadPermissionPolicyParameter() did not return error for invalid policy")
+	}
+
+	if _, err := loadPermissionPolicyParameter(context.TODO(), client, "/typo"); err == nil || !regexp.MustCompile("adminstration").MatchString(err.Error()) {
+		t.Errorf("loadPermissionPolicyParameter() error = %v, want error about unknown permission adminstration", err)
+	}
+}
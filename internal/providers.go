@@ -0,0 +1,74 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/catnekaise/ghrawel-tokenprovider-lambda-go/pkg/api"
+	"os"
+	"strings"
+)
+
+// loadProviders reads the providers file, the registry of the token providers the server, Function URLs,
+// HTTP APIs and direct invocations serve by name.
+func loadProviders(name string) (map[string]api.ProviderConfig, error) {
+
+	data, err := os.ReadFile(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var config api.ProvidersConfig
+
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, errors.New(fmt.Sprintf("%s is not a valid providers file: %s", name, err.Error()))
+	}
+
+	result := map[string]api.ProviderConfig{}
+
+	for _, provider := range config.Providers {
+
+		if provider.ProviderName == "" {
+			return nil, errors.New(fmt.Sprintf("%s contains a provider without providerName", name))
+		}
+
+		if _, ok := result[provider.ProviderName]; ok {
+			return nil, errors.New(fmt.Sprintf("%s contains provider %q more than once", name, provider.ProviderName))
+		}
+
+		result[provider.ProviderName] = provider
+	}
+
+	return result, nil
+}
+
+// providerInput builds the input of the named provider of the providers file. A static owner of the provider
+// takes the place of an omitted owner.
+func (s *Service) providerInput(ctx context.Context, name string, tokenRequest api.TokenRequest) (api.Input, *errorResponse) {
+
+	if s.providers == nil {
+		s.logger.ErrorContext(ctx, "ProvidersError - No providers are configured, set TOKEN_PROVIDERS_FILE")
+		return api.Input{}, createErrorResponse(api.ErrorCodeConfiguration, "Error", 500)
+	}
+
+	provider, ok := s.providers[name]
+
+	if !ok {
+		s.logger.InfoContext(ctx, fmt.Sprintf("ProviderNotFound - No provider named %q", name))
+		return api.Input{}, createErrorResponse(api.ErrorCodeProviderNotFound, "Token provider not found", 404)
+	}
+
+	if provider.Owner != "" {
+
+		if tokenRequest.Owner != "" && !strings.EqualFold(tokenRequest.Owner, provider.Owner) {
+			s.logger.InfoContext(ctx, fmt.Sprintf("InputError - Owner %q does not match owner of provider %q", tokenRequest.Owner, name))
+			return api.Input{}, createErrorResponse(api.ErrorCodeInvalidOwner, "Value of provided owner is invalid", 400)
+		}
+
+		tokenRequest.Owner = provider.Owner
+	}
+
+	return api.Input{TokenRequest: tokenRequest, TokenContext: provider.TokenContext}, nil
+}
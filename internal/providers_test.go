@@ -0,0 +1,101 @@
+package internal
+
+import (
+	"context"
+	"github.com/catnekaise/ghrawel-tokenprovider-lambda-go/pkg/api"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_loadProviders(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		content  string
+		wantName string
+		wantErr  bool
+	}{
+		{
+			name:     "valid",
+			content:  `{"providers": [{"providerName": "example", "app": {"id": 1234, "name": "default"}}]}`,
+			wantName: "example",
+		},
+		{
+			name:    "invalid json",
+			content: `{"providers": {}}`,
+			wantErr: true,
+		},
+		{
+			name:    "provider without name",
+			content: `{"providers": [{"app": {"id": 1234, "name": "default"}}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "provider more than once",
+			content: `{"providers": [{"providerName": "example"}, {"providerName": "example"}]}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			name := filepath.Join(t.TempDir(), "providers.json")
+
+			if err := os.WriteFile(name, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := loadProviders(name)
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadProviders() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if _, ok := got[tt.wantName]; !tt.wantErr && !ok {
+				t.Errorf("loadProviders() got = %v, want provider %q", got, tt.wantName)
+			}
+		})
+	}
+}
+
+func TestService_providerInput(t *testing.T) {
+
+	service := newTestService(t, Options{Providers: map[string]api.ProviderConfig{
+		"static": {Owner: "catnekaise", TokenContext: api.TokenContext{ProviderName: "static"}},
+	}})
+
+	tests := []struct {
+		name         string
+		provider     string
+		owner        string
+		wantOwner    string
+		wantCode     string
+		wantProvider string
+	}{
+		{name: "static owner", provider: "static", wantOwner: "catnekaise", wantProvider: "static"},
+		{name: "matching owner", provider: "static", owner: "CATNEKAISE", wantOwner: "catnekaise", wantProvider: "static"},
+		{name: "other owner", provider: "static", owner: "other", wantCode: api.ErrorCodeInvalidOwner},
+		{name: "unknown provider", provider: "unknown", wantCode: api.ErrorCodeProviderNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, e := service.providerInput(context.TODO(), tt.provider, api.TokenRequest{Owner: tt.owner})
+
+			if tt.wantCode != "" {
+				if e == nil || e.Code != tt.wantCode {
+					t.Errorf("providerInput() error = %v, want %v", e, tt.wantCode)
+				}
+				return
+			}
+
+			if e != nil {
+				t.Fatalf("providerInput() error = %v", e)
+			}
+
+			if got.TokenRequest.Owner != tt.wantOwner || got.TokenContext.ProviderName != tt.wantProvider {
+				t.Errorf("providerInput() got = %v, %v, want %v, %v", got.TokenRequest.Owner, got.TokenContext.ProviderName, tt.wantOwner, tt.wantProvider)
+			}
+		})
+	}
+}
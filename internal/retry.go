@@ -0,0 +1,220 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	retryMaxAttempts = 3
+	retryBaseDelay   = 200 * time.Millisecond
+	retryMaxDelay    = 5 * time.Second
+	// retryMaxWait bounds waits requested by GitHub. A longer wait would hold the Lambda invocation, and with it
+	// the caller, for longer than retrying later from the caller does.
+	retryMaxWait = 20 * time.Second
+)
+
+// retryTransport retries requests to GitHub that failed with a transient error or a rate limit, waiting as
+// long as GitHub asks through Retry-After or X-RateLimit-Reset and otherwise backing off exponentially with
+// jitter. No retry is attempted when the wait would not end before the deadline of the request context.
+// Requests that are not idempotent, such as creating an installation token, are only retried when GitHub
+// explicitly asks for it through Retry-After, and never after a network error since the request may have
+// been processed.
+type retryTransport struct {
+	base        http.RoundTripper
+	maxAttempts int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+	maxWait     time.Duration
+	// now is the clock of GitHub, which rate limit resets are relative to. Deadlines of request contexts are
+	// always compared with the wall clock.
+	now func() time.Time
+}
+
+// retriesExhaustedError is returned when GitHub did not respond successfully within the attempts, or the
+// time, available.
+type retriesExhaustedError struct {
+	attempts    int
+	statusCode  int
+	rateLimited bool
+	retryAfter  time.Duration
+	err         error
+}
+
+func (e *retriesExhaustedError) Error() string {
+
+	if e.err != nil {
+		return fmt.Sprintf("giving up after %v attempt(s): %s", e.attempts, e.err.Error())
+	}
+
+	return fmt.Sprintf("giving up after %v attempt(s), last status %v", e.attempts, e.statusCode)
+}
+
+func (e *retriesExhaustedError) Unwrap() error {
+	return e.err
+}
+
+func newRetryTransport(base http.RoundTripper) *retryTransport {
+
+	return &retryTransport{
+		base:        base,
+		maxAttempts: retryMaxAttempts,
+		baseDelay:   retryBaseDelay,
+		maxDelay:    retryMaxDelay,
+		maxWait:     retryMaxWait,
+		now:         time.Now,
+	}
+}
+
+// withNow returns a copy of the transport, sharing its connections, that reads the time from now.
+func (t *retryTransport) withNow(now func() time.Time) *retryTransport {
+
+	c := *t
+	c.now = now
+
+	return &c
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	ctx := req.Context()
+
+	for attempt := 1; ; attempt++ {
+
+		attemptReq := req
+
+		if attempt > 1 && req.Body != nil {
+
+			if req.GetBody == nil {
+				return nil, errors.New("request body can not be replayed")
+			}
+
+			body, err := req.GetBody()
+
+			if err != nil {
+				return nil, err
+			}
+
+			attemptReq = req.Clone(ctx)
+			attemptReq.Body = body
+		}
+
+		resp, err := t.base.RoundTrip(attemptReq)
+
+		if err != nil && (ctx.Err() != nil || !isIdempotent(req)) {
+			return nil, err
+		}
+
+		if err == nil && !isRetryableResponse(req, resp) {
+			return resp, nil
+		}
+
+		delay, requested := t.delay(attempt, resp)
+		exhausted := &retriesExhaustedError{attempts: attempt, retryAfter: delay, err: err}
+
+		if resp != nil {
+			exhausted.statusCode = resp.StatusCode
+			exhausted.rateLimited = isRateLimited(resp)
+		}
+
+		closeBody(resp)
+
+		if attempt >= t.maxAttempts || (requested && delay > t.maxWait) || !t.fitsDeadline(ctx, delay) {
+			return nil, exhausted
+		}
+
+		timer := time.NewTimer(delay)
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// delay returns how long to wait before the next attempt and whether GitHub asked for that wait.
+func (t *retryTransport) delay(attempt int, resp *http.Response) (time.Duration, bool) {
+
+	if resp != nil {
+
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+			return time.Duration(seconds) * time.Second, true
+		}
+
+		if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+			if reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+				return max(time.Unix(reset, 0).Sub(t.now()), 0), true
+			}
+		}
+	}
+
+	backoff := min(t.baseDelay<<(attempt-1), t.maxDelay)
+
+	return time.Duration(rand.Int63n(int64(backoff) + 1)), false
+}
+
+func (t *retryTransport) fitsDeadline(ctx context.Context, delay time.Duration) bool {
+
+	deadline, ok := ctx.Deadline()
+
+	return !ok || time.Now().Add(delay).Before(deadline)
+}
+
+func isRetryableResponse(req *http.Request, resp *http.Response) bool {
+
+	if !isIdempotent(req) {
+
+		switch resp.StatusCode {
+		case http.StatusForbidden, http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable:
+			return resp.Header.Get("Retry-After") != ""
+		}
+
+		return false
+	}
+
+	switch resp.StatusCode {
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout, http.StatusTooManyRequests:
+		return true
+	case http.StatusForbidden:
+		return isRateLimited(resp)
+	}
+
+	return false
+}
+
+// isRateLimited reports whether GitHub refused the request because of a primary or secondary rate limit.
+func isRateLimited(resp *http.Response) bool {
+
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusForbidden:
+		return resp.Header.Get("Retry-After") != "" || resp.Header.Get("X-RateLimit-Remaining") == "0"
+	}
+
+	return false
+}
+
+func isIdempotent(req *http.Request) bool {
+
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+
+	return false
+}
+
+func closeBody(resp *http.Response) {
+
+	if resp != nil && resp.Body != nil {
+		_ = resp.Body.Close()
+	}
+}
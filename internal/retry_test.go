@@ -0,0 +1,266 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func Test_retryTransport(t *testing.T) {
+
+	tests := []struct {
+		name            string
+		method          string
+		responses       []int
+		header          http.Header
+		deadline        time.Duration
+		wantAttempts    int
+		wantStatusCode  int
+		wantRetryAfter  time.Duration
+		wantRateLimited bool
+	}{
+		{
+			name:           "success after bad gateway",
+			method:         http.MethodPut,
+			responses:      []int{502, 201},
+			wantAttempts:   2,
+			wantStatusCode: 201,
+		},
+		{
+			name:         "exhausted",
+			method:       http.MethodPut,
+			responses:    []int{503, 503, 503, 503},
+			wantAttempts: 3,
+		},
+		{
+			name:           "post is not retried after bad gateway",
+			responses:      []int{502, 201},
+			wantAttempts:   1,
+			wantStatusCode: 502,
+		},
+		{
+			name:           "post is retried when asked",
+			responses:      []int{503, 201},
+			header:         http.Header{"Retry-After": []string{"0"}},
+			wantAttempts:   2,
+			wantStatusCode: 201,
+		},
+		{
+			name:           "not found is not retried",
+			responses:      []int{404, 201},
+			wantAttempts:   1,
+			wantStatusCode: 404,
+		},
+		{
+			name:           "forbidden is not retried",
+			responses:      []int{403, 201},
+			wantAttempts:   1,
+			wantStatusCode: 403,
+		},
+		{
+			name:           "secondary rate limit",
+			responses:      []int{403, 201},
+			header:         http.Header{"Retry-After": []string{"0"}},
+			wantAttempts:   2,
+			wantStatusCode: 201,
+		},
+		{
+			name:            "retry after beyond max wait",
+			responses:       []int{429, 201},
+			header:          http.Header{"Retry-After": []string{"60"}},
+			wantAttempts:    1,
+			wantRetryAfter:  time.Minute,
+			wantRateLimited: true,
+		},
+		{
+			name:            "rate limit reset beyond deadline",
+			method:          http.MethodGet,
+			responses:       []int{403, 201},
+			header:          http.Header{"X-Ratelimit-Remaining": []string{"0"}, "X-Ratelimit-Reset": []string{strconv.FormatInt(time.Now().Add(10*time.Second).Unix(), 10)}},
+			deadline:        time.Second,
+			wantAttempts:    1,
+			wantRetryAfter:  10 * time.Second,
+			wantRateLimited: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			attempts := 0
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+				if body, _ := io.ReadAll(r.Body); string(body) != `{"repositories":["example-repo"]}` {
+					t.Errorf("attempt %v got body = %q", attempts, body)
+				}
+
+				for key, values := range tt.header {
+					w.Header()[key] = values
+				}
+
+				w.WriteHeader(tt.responses[attempts])
+				attempts++
+			}))
+			defer server.Close()
+
+			transport := newRetryTransport(http.DefaultTransport)
+			transport.baseDelay = time.Millisecond
+
+			ctx := context.Background()
+
+			if tt.deadline > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.deadline)
+				defer cancel()
+			}
+
+			method := http.MethodPost
+
+			if tt.method != "" {
+				method = tt.method
+			}
+
+			req, _ := http.NewRequestWithContext(ctx, method, server.URL, bytes.NewBufferString(`{"repositories":["example-repo"]}`))
+
+			resp, err := transport.RoundTrip(req)
+
+			if attempts != tt.wantAttempts {
+				t.Errorf("RoundTrip() attempts = %v, want %v", attempts, tt.wantAttempts)
+			}
+
+			if tt.wantStatusCode != 0 {
+				if err != nil {
+					t.Fatalf("RoundTrip() error = %v", err)
+				}
+
+				if resp.StatusCode != tt.wantStatusCode {
+					t.Errorf("RoundTrip() status = %v, want %v", resp.StatusCode, tt.wantStatusCode)
+				}
+
+				return
+			}
+
+			var exhausted *retriesExhaustedError
+
+			if !errors.As(err, &exhausted) {
+				t.Fatalf("RoundTrip() error = %v, want retriesExhaustedError", err)
+			}
+
+			if tt.wantRetryAfter != 0 && (exhausted.retryAfter < tt.wantRetryAfter-2*time.Second || exhausted.retryAfter > tt.wantRetryAfter) {
+				t.Errorf("RoundTrip() retryAfter = %v, want %v", exhausted.retryAfter, tt.wantRetryAfter)
+			}
+
+			if exhausted.rateLimited != tt.wantRateLimited {
+				t.Errorf("RoundTrip() rateLimited = %v, want %v", exhausted.rateLimited, tt.wantRateLimited)
+			}
+		})
+	}
+}
+
+func Test_retryTransport_networkError(t *testing.T) {
+
+	tests := []struct {
+		name         string
+		method       string
+		wantAttempts int
+	}{
+		{
+			name:         "get is retried",
+			method:       http.MethodGet,
+			wantAttempts: 3,
+		},
+		{
+			name:         "post is not retried",
+			method:       http.MethodPost,
+			wantAttempts: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			attempts := 0
+
+			transport := newRetryTransport(failingTransport(func() {
+				attempts++
+			}))
+			transport.baseDelay = time.Millisecond
+
+			req, _ := http.NewRequest(tt.method, "https://api.github.com/app/installations/1/access_tokens", nil)
+
+			if _, err := transport.RoundTrip(req); err == nil {
+				t.Fatalf("RoundTrip() error = nil")
+			}
+
+			if attempts != tt.wantAttempts {
+				t.Errorf("RoundTrip() attempts = %v, want %v", attempts, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+// failingTransport fails every request with a network error.
+type failingTransport func()
+
+func (f failingTransport) RoundTrip(*http.Request) (*http.Response, error) {
+
+	f()
+
+	return nil, errors.New("connection reset by peer")
+}
+
+func TestNewService_retryTransportNow(t *testing.T) {
+
+	tests := []struct {
+		name         string
+		offset       time.Duration
+		retryAfter   string
+		wantAttempts int
+	}{
+		{name: "clock in the future still retries", offset: time.Hour, retryAfter: "0", wantAttempts: 2},
+		{name: "clock in the past still respects the deadline", offset: -time.Hour, retryAfter: "10", wantAttempts: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			attempts := 0
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+				attempts++
+
+				if attempts == 1 {
+					w.Header().Set("Retry-After", tt.retryAfter)
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer server.Close()
+
+			service := newTestService(t, Options{Now: func() time.Time { return time.Now().Add(tt.offset) }})
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+
+			resp, err := service.transport.RoundTrip(req)
+			closeBody(resp)
+
+			if attempts != tt.wantAttempts {
+				t.Errorf("RoundTrip() attempts = %v, want %v, error = %v", attempts, tt.wantAttempts, err)
+			}
+
+			if githubTransport.now().Sub(time.Now()) > time.Minute {
+				t.Error("NewService() changed the clock of the shared transport")
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"github.com/catnekaise/ghrawel-tokenprovider-lambda-go/pkg/api"
+	"net/http"
+)
+
+// handleRevoke revokes an installation token before it expires. The token authenticates the request itself,
+// so neither the private key nor the installation is needed.
+func (s *Service) handleRevoke(ctx context.Context, req api.Input) (*api.RevokeResponse, error) {
+
+	token, ok := readToken(req.TokenRequest.Token)
+
+	if !ok {
+		s.logger.InfoContext(ctx, "InputError - Value of provided token is invalid")
+		return nil, createErrorResponse(api.ErrorCodeInvalidToken, "Value of provided token is invalid", 400)
+	}
+
+	baseUrl, err := s.githubBaseUrl(req.TokenContext.App)
+
+	if err != nil {
+		s.logger.ErrorContext(ctx, fmt.Sprintf("ConfigurationError - %s", err.Error()))
+		return nil, createErrorResponse(api.ErrorCodeConfiguration, "Error", 500)
+	}
+
+	client, err := newGitHubClient(&http.Client{Transport: s.transport}, baseUrl)
+
+	if err != nil {
+		s.logger.ErrorContext(ctx, fmt.Sprintf("GitHubClientError - %s", err.Error()))
+		return nil, createErrorResponse(api.ErrorCodeConfiguration, "Error", 500)
+	}
+
+	_, err = client.WithAuthToken(token).Apps.RevokeInstallationToken(ctx)
+
+	if isUnauthorized(err) {
+		s.logger.InfoContext(ctx, "RevokeRejected - Token is expired or already revoked")
+		return nil, createErrorResponse(api.ErrorCodeTokenExpired, "Token is expired or already revoked", 410)
+	} else if err != nil {
+		e := classifyGitHubError(err)
+		s.logErrorResponse(ctx, e, fmt.Sprintf("RevokeError - %s", err.Error()))
+		return nil, e
+	}
+
+	s.logger.InfoContext(ctx, "TokenRevoked")
+
+	return &api.RevokeResponse{Revoked: true}, nil
+}
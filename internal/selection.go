@@ -0,0 +1,441 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/catnekaise/ghrawel-tokenprovider-lambda-go/pkg/api"
+	"github.com/google/go-github/v60/github"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// maxTokenRepositories is the most repositories GitHub scopes an installation token to.
+	maxTokenRepositories = 500
+	// lookupTokenRevokeTimeout bounds revoking the lookup token, which is done even when the request has been
+	// cancelled or has run out of time.
+	lookupTokenRevokeTimeout = 5 * time.Second
+)
+
+// repositoryPropertyValues are the custom property values of a repository, as listed by GitHub.
+type repositoryPropertyValues struct {
+	RepositoryId   int64           `json:"repository_id"`
+	RepositoryName string          `json:"repository_name"`
+	Properties     []propertyValue `json:"properties"`
+}
+
+// propertyValue is the value of a custom property. github.CustomPropertyValue is not used since its value is
+// a string, and the value of a multi select property is an array of strings.
+type propertyValue struct {
+	PropertyName string         `json:"property_name"`
+	Values       propertyValues `json:"value"`
+}
+
+// propertyValues decodes a value that is null, a string or an array of strings.
+type propertyValues []string
+
+func (v *propertyValues) UnmarshalJSON(data []byte) error {
+
+	var value any
+
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	switch value := value.(type) {
+	case nil:
+		*v = nil
+	case string:
+		*v = propertyValues{value}
+	case []any:
+		values := make(propertyValues, 0, len(value))
+
+		for _, item := range value {
+
+			text, ok := item.(string)
+
+			if !ok {
+				return errors.New(fmt.Sprintf("custom property value %s is not a string", data))
+			}
+
+			values = append(values, text)
+		}
+
+		*v = values
+	default:
+		return errors.New(fmt.Sprintf("custom property value %s is neither a string nor an array", data))
+	}
+
+	return nil
+}
+
+// repositoryRef is a repository resolved through the installation.
+type repositoryRef struct {
+	id   int64
+	name string
+}
+
+// repositorySelectionError is returned when the repositories resolved for a target rule cannot be scoped to
+// a token.
+type repositorySelectionError struct {
+	code    string
+	message string
+	reason  string
+}
+
+func (e *repositorySelectionError) Error() string {
+
+	return e.reason
+}
+
+func selectionErrorResponse(err error) (*errorResponse, bool) {
+
+	var e *repositorySelectionError
+
+	if !errors.As(err, &e) {
+		return nil, false
+	}
+
+	return createErrorResponse(e.code, e.message, 422), true
+}
+
+// selectRepositories returns the repositories the token is scoped to. Repositories of the selection modes
+// that depend on the repositories of the owner are resolved through the installation and narrowed to the
+// repositories of the request, if any. The repositories of the request are returned as is otherwise.
+func (s *Service) selectRepositories(ctx context.Context, client *github.Client, req api.Input, owner string, repos []string) ([]string, error) {
+
+	targetRule := req.TokenContext.TargetRule
+
+	selector, err := readSelector(targetRule.RepositorySelectionMode, req.TokenRequest)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var refs []repositoryRef
+
+	switch targetRule.RepositorySelectionMode {
+	case api.RepositorySelectionModeByProperty:
+		refs, err = s.listRepositoriesByProperty(ctx, client, req.TokenContext, owner)
+	case api.RepositorySelectionModeByTopic:
+		refs, err = s.listRepositoriesByTopic(ctx, client, req.TokenContext.App.Id, owner, selector)
+	case api.RepositorySelectionModeByTeam:
+		refs, err = s.listRepositoriesByTeam(ctx, client, req.TokenContext.App.Id, owner, selector)
+	default:
+		return repos, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return limitSelection(targetRule, owner, intersectRepositories(refs, repos))
+}
+
+// intersectRepositories returns the repositories that are also requested by name or ID, or all repositories
+// when none are requested.
+func intersectRepositories(refs []repositoryRef, requested []string) []repositoryRef {
+
+	if len(requested) == 0 {
+		return refs
+	}
+
+	var result []repositoryRef
+
+	for _, ref := range refs {
+		for _, repository := range requested {
+
+			if id, ok := readRepoId(repository); (ok && id == ref.id) || strings.EqualFold(ref.name, repository) {
+				result = append(result, ref)
+				break
+			}
+		}
+	}
+
+	return result
+}
+
+// limitSelection removes the repositories the target rule does not allow. A resolved selection is never
+// empty, since a token without repositories has access to all repositories of the installation.
+func limitSelection(targetRule api.TargetRule, owner string, refs []repositoryRef) ([]string, error) {
+
+	var selected []string
+
+	for _, ref := range refs {
+
+		allowed, err := isRepositoryAllowed(targetRule, ref.name, ref.id)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if allowed {
+			selected = append(selected, ref.name)
+		}
+	}
+
+	if len(selected) == 0 {
+		return nil, &repositorySelectionError{
+			code:    api.ErrorCodeNoRepositoriesSelected,
+			message: "No repositories match the target rule",
+			reason:  fmt.Sprintf("No repositories of %s match the target rule", owner),
+		}
+	}
+
+	if len(selected) > maxTokenRepositories {
+		return nil, &repositorySelectionError{
+			code:    api.ErrorCodeTooManyRepositories,
+			message: fmt.Sprintf("More than %v repositories match the target rule", maxTokenRepositories),
+			reason:  fmt.Sprintf("%v repositories of %s match the target rule, tokens are limited to %v", len(selected), owner, maxTokenRepositories),
+		}
+	}
+
+	return selected, nil
+}
+
+// listRepositoriesByProperty lists the repositories of the organization whose custom properties match the
+// property filters of the target rule.
+func (s *Service) listRepositoriesByProperty(ctx context.Context, client *github.Client, tokenContext api.TokenContext, owner string) ([]repositoryRef, error) {
+
+	permissions := api.Permissions{OrganizationCustomProperties: github.String("read")}
+	lookup, revoke, err := s.lookupClient(ctx, client, tokenContext.App.Id, owner, permissions, "Custom properties read access for BY_PROPERTY")
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer revoke()
+
+	var refs []repositoryRef
+
+	page := 1
+
+	for {
+		httpReq, err := lookup.NewRequest(http.MethodGet, fmt.Sprintf("orgs/%s/properties/values?per_page=100&page=%v", owner, page), nil)
+
+		if err != nil {
+			return nil, err
+		}
+
+		var values []repositoryPropertyValues
+
+		resp, err := lookup.Do(ctx, httpReq, &values)
+
+		if isNotFound(err) {
+			return nil, &repositorySelectionError{
+				code:    api.ErrorCodeInvalidRepositorySelection,
+				message: "Repositories can only be selected by custom properties when the owner is an organization",
+				reason:  fmt.Sprintf("Custom properties of %s were not found, BY_PROPERTY needs an organization owner", owner),
+			}
+		} else if err != nil {
+			return nil, err
+		}
+
+		for _, value := range values {
+
+			if matchProperties(tokenContext.TargetRule.Properties, value.Properties) {
+				refs = append(refs, repositoryRef{id: value.RepositoryId, name: value.RepositoryName})
+			}
+		}
+
+		if resp.NextPage == 0 {
+			break
+		}
+
+		page = resp.NextPage
+	}
+
+	s.logger.DebugContext(ctx, fmt.Sprintf("%v repositories of %s match the properties of the target rule", len(refs), owner))
+
+	return refs, nil
+}
+
+// listRepositoriesByTopic lists the repositories of the installation on owner that have the topic.
+func (s *Service) listRepositoriesByTopic(ctx context.Context, client *github.Client, appId int64, owner string, topic string) ([]repositoryRef, error) {
+
+	permissions := api.Permissions{Metadata: github.String("read")}
+	lookup, revoke, err := s.lookupClient(ctx, client, appId, owner, permissions, "Metadata read access for BY_TOPIC")
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer revoke()
+
+	repositories, err := listInstallationRepositories(ctx, lookup)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var refs []repositoryRef
+
+	for _, repository := range repositories {
+		for _, value := range repository.Topics {
+			if strings.EqualFold(value, topic) {
+				refs = append(refs, repositoryRef{id: repository.GetID(), name: repository.GetName()})
+				break
+			}
+		}
+	}
+
+	s.logger.DebugContext(ctx, fmt.Sprintf("%v repositories of %s have topic %q", len(refs), owner, topic))
+
+	return refs, nil
+}
+
+// listRepositoriesByTeam lists the repositories of the installation on owner that the team owns, which are
+// the repositories the team has admin access to.
+func (s *Service) listRepositoriesByTeam(ctx context.Context, client *github.Client, appId int64, owner string, team string) ([]repositoryRef, error) {
+
+	permissions := api.Permissions{Members: github.String("read"), Metadata: github.String("read")}
+	lookup, revoke, err := s.lookupClient(ctx, client, appId, owner, permissions, "Members and Metadata read access for BY_TEAM")
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer revoke()
+
+	repositories, err := listInstallationRepositories(ctx, lookup)
+
+	if err != nil {
+		return nil, err
+	}
+
+	accessible := map[int64]bool{}
+
+	for _, repository := range repositories {
+		accessible[repository.GetID()] = true
+	}
+
+	var refs []repositoryRef
+
+	opts := &github.ListOptions{PerPage: 100}
+
+	for {
+		teamRepositories, resp, err := lookup.Teams.ListTeamReposBySlug(ctx, owner, team, opts)
+
+		if isNotFound(err) {
+			return nil, &repositorySelectionError{
+				code:    api.ErrorCodeNoRepositoriesSelected,
+				message: "No repositories match the target rule",
+				reason:  fmt.Sprintf("Team %q of %s was not found", team, owner),
+			}
+		} else if err != nil {
+			return nil, err
+		}
+
+		for _, repository := range teamRepositories {
+			if repository.GetPermissions()["admin"] && accessible[repository.GetID()] {
+				refs = append(refs, repositoryRef{id: repository.GetID(), name: repository.GetName()})
+			}
+		}
+
+		if resp.NextPage == 0 {
+			break
+		}
+
+		opts.Page = resp.NextPage
+	}
+
+	s.logger.DebugContext(ctx, fmt.Sprintf("%v repositories of %s are owned by team %q", len(refs), owner, team))
+
+	return refs, nil
+}
+
+// listInstallationRepositories lists the repositories the installation of the token has access to.
+func listInstallationRepositories(ctx context.Context, lookup *github.Client) ([]*github.Repository, error) {
+
+	var repositories []*github.Repository
+
+	opts := &github.ListOptions{PerPage: 100}
+
+	for {
+		result, resp, err := lookup.Apps.ListRepos(ctx, opts)
+
+		if err != nil {
+			return nil, err
+		}
+
+		repositories = append(repositories, result.Repositories...)
+
+		if resp.NextPage == 0 {
+			break
+		}
+
+		opts.Page = resp.NextPage
+	}
+
+	return repositories, nil
+}
+
+// matchProperties reports whether, for every filter, the property named by the filter has one of its values.
+// Names and values are compared ignoring case, and a multi select property matches when any of its values
+// does.
+func matchProperties(filters []api.PropertyFilter, properties []propertyValue) bool {
+
+	for _, filter := range filters {
+
+		matched := false
+
+		for _, property := range properties {
+
+			if !strings.EqualFold(property.PropertyName, filter.Name) {
+				continue
+			}
+
+			for _, value := range filter.Values {
+				for _, propertyValue := range property.Values {
+					if strings.EqualFold(propertyValue, value) {
+						matched = true
+					}
+				}
+			}
+		}
+
+		if !matched {
+			return false
+		}
+	}
+
+	return true
+}
+
+// lookupClient creates an installation token with the permissions needed to resolve repositories, and
+// returns a client using that token together with a func revoking it. The token is never returned to the
+// caller. A token of its own is needed since the requested token can only be created once its repositories
+// are known, and may lack the permissions needed to list them. When the installation does not grant the
+// permissions, the error names required, since the caller never asked for them.
+func (s *Service) lookupClient(ctx context.Context, client *github.Client, appId int64, owner string, permissions api.Permissions, required string) (*github.Client, func(), error) {
+
+	token, err := s.getToken(ctx, client, appId, owner, permissions, nil)
+
+	if hasStatusCode(err, http.StatusUnprocessableEntity) {
+		return nil, nil, &repositorySelectionError{
+			code:    api.ErrorCodePermissionsNotGranted,
+			message: fmt.Sprintf("The GitHub App needs %s", required),
+			reason:  fmt.Sprintf("Token used to resolve repositories of %s was not created, the GitHub App needs %s: %s", owner, required, err.Error()),
+		}
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	lookup := github.NewClient(&http.Client{Transport: s.transport}).WithAuthToken(token.Token)
+	lookup.BaseURL = client.BaseURL
+
+	revoke := func() {
+
+		revokeCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), lookupTokenRevokeTimeout)
+		defer cancel()
+
+		if _, err := lookup.Apps.RevokeInstallationToken(revokeCtx); err != nil {
+			s.logger.WarnContext(revokeCtx, fmt.Sprintf("LookupTokenError - Token used to resolve repositories was not revoked: %s", err.Error()))
+		}
+	}
+
+	return lookup, revoke, nil
+}
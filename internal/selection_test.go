@@ -0,0 +1,367 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"github.com/catnekaise/ghrawel-tokenprovider-lambda-go/pkg/api"
+	"github.com/catnekaise/ghrawel-tokenprovider-lambda-go/pkg/fakegithub"
+	"github.com/google/go-github/v60/github"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_handle_byProperty(t *testing.T) {
+
+	repositories := []fakegithub.Repository{
+		{Id: 10, Name: "web", Properties: map[string]any{"team": "frontend", "tier": "1", "languages": []string{"Go", "TypeScript"}}},
+		{Id: 11, Name: "api", Properties: map[string]any{"team": "backend", "tier": "1", "languages": []string{"Go"}}},
+		{Id: 12, Name: "batch", Properties: map[string]any{"team": "backend", "tier": "2"}},
+		{Id: 13, Name: "docs", Properties: map[string]any{"team": nil}},
+	}
+
+	many := make([]fakegithub.Repository, 0, maxTokenRepositories+1)
+
+	for i := 0; i <= maxTokenRepositories; i++ {
+		many = append(many, fakegithub.Repository{Id: int64(100 + i), Name: fmt.Sprintf("repo-%v", i), Properties: map[string]any{"team": "platform"}})
+	}
+
+	server := fakegithub.New(t, 1234,
+		fakegithub.Installation{
+			Id:           1,
+			Owner:        "catnekaise",
+			Permissions:  map[string]string{"contents": "write", "organization_custom_properties": "read"},
+			Repositories: append(repositories, many...),
+		},
+		fakegithub.Installation{
+			Id:           2,
+			Owner:        "nekaise",
+			Permissions:  map[string]string{"contents": "write"},
+			Repositories: repositories,
+		},
+		fakegithub.Installation{
+			Id:           3,
+			Owner:        "octocat",
+			User:         true,
+			Permissions:  map[string]string{"contents": "write", "organization_custom_properties": "read"},
+			Repositories: repositories,
+		},
+	)
+
+	t.Setenv("GITHUB_APP_PRIVATE_KEY_DEFAULT", string(server.PrivateKey()))
+
+	service := newTestService(t, Options{AllowedBaseUrls: []string{server.URL}})
+
+	tests := []struct {
+		name           string
+		owner          string
+		repo           *string
+		properties     []api.PropertyFilter
+		deny           []string
+		want           []string
+		wantErrPattern string
+	}{
+		{
+			name:       "single property",
+			owner:      "catnekaise",
+			properties: []api.PropertyFilter{{Name: "team", Values: []string{"backend"}}},
+			want:       []string{"api", "batch"},
+		},
+		{
+			name:       "every filter has to match",
+			owner:      "catnekaise",
+			properties: []api.PropertyFilter{{Name: "team", Values: []string{"backend", "frontend"}}, {Name: "tier", Values: []string{"1"}}},
+			want:       []string{"web", "api"},
+		},
+		{
+			name:       "values ignore case",
+			owner:      "catnekaise",
+			properties: []api.PropertyFilter{{Name: "Team", Values: []string{"BACKEND"}}},
+			want:       []string{"api", "batch"},
+		},
+		{
+			name:       "multi select property",
+			owner:      "catnekaise",
+			properties: []api.PropertyFilter{{Name: "languages", Values: []string{"typescript", "rust"}}},
+			want:       []string{"web"},
+		},
+		{
+			name:       "multi select property with several matching repositories",
+			owner:      "catnekaise",
+			properties: []api.PropertyFilter{{Name: "languages", Values: []string{"go"}}},
+			want:       []string{"web", "api"},
+		},
+		{
+			name:       "denied repositories are removed",
+			owner:      "catnekaise",
+			properties: []api.PropertyFilter{{Name: "team", Values: []string{"backend"}}},
+			deny:       []string{"batch"},
+			want:       []string{"api"},
+		},
+		{
+			name:           "no repositories match",
+			owner:          "catnekaise",
+			properties:     []api.PropertyFilter{{Name: "team", Values: []string{"design"}}},
+			wantErrPattern: `"code":"NO_REPOSITORIES_SELECTED"`,
+		},
+		{
+			name:           "too many repositories",
+			owner:          "catnekaise",
+			properties:     []api.PropertyFilter{{Name: "team", Values: []string{"platform"}}},
+			wantErrPattern: `"code":"TOO_MANY_REPOSITORIES"`,
+		},
+		{
+			name:           "repositories in request",
+			owner:          "catnekaise",
+			repo:           github.String("web"),
+			properties:     []api.PropertyFilter{{Name: "team", Values: []string{"frontend"}}},
+			wantErrPattern: `"code":"INVALID_REPOSITORY_SELECTION"`,
+		},
+		{
+			name:           "owner is a user",
+			owner:          "octocat",
+			properties:     []api.PropertyFilter{{Name: "team", Values: []string{"backend"}}},
+			wantErrPattern: `"code":"INVALID_REPOSITORY_SELECTION","message":"Repositories can only be selected by custom properties when the owner is an organization"`,
+		},
+		{
+			name:           "custom properties not granted",
+			owner:          "nekaise",
+			properties:     []api.PropertyFilter{{Name: "team", Values: []string{"backend"}}},
+			wantErrPattern: `"code":"PERMISSIONS_NOT_GRANTED","message":"The GitHub App needs Custom properties read access for BY_PROPERTY"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := createTestInput(tt.owner, tt.repo, github.String("DYNAMIC_OWNER"), github.String(api.RepositorySelectionModeByProperty))
+			req.TokenContext.App.BaseUrl = server.URL
+			req.TokenContext.TargetRule.Properties = tt.properties
+			req.TokenContext.TargetRule.DenyRepositories = tt.deny
+
+			before := len(server.Tokens())
+			got, err := service.handleInput(context.TODO(), req)
+
+			for _, token := range server.Tokens()[before:] {
+				if _, ok := token.Permissions["organization_custom_properties"]; ok && !token.Revoked {
+					t.Errorf("handleInput() did not revoke lookup token %v", token.Token)
+				}
+			}
+
+			if tt.wantErrPattern != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErrPattern) {
+					t.Errorf("handleInput() error = %v, want %v", err, tt.wantErrPattern)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("handleInput() error = %v", err)
+			}
+
+			var names []string
+
+			for _, repository := range got.Repositories {
+				names = append(names, repository.Name)
+			}
+
+			if got.RepositorySelection != "selected" || !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("handleInput() got = %v %v, want selected %v", got.RepositorySelection, names, tt.want)
+			}
+		})
+	}
+}
+
+func Test_handle_byTopicOrTeam(t *testing.T) {
+
+	server := fakegithub.New(t, 1234,
+		fakegithub.Installation{
+			Id:          1,
+			Owner:       "catnekaise",
+			Permissions: map[string]string{"contents": "write", "members": "read", "metadata": "read"},
+			Repositories: []fakegithub.Repository{
+				{Id: 10, Name: "web", Topics: []string{"frontend", "service"}},
+				{Id: 11, Name: "api", Topics: []string{"backend", "service"}},
+				{Id: 12, Name: "batch", Topics: []string{"backend"}},
+				{Id: 13, Name: "secret", Topics: []string{"backend"}},
+			},
+			Selected: []string{"web", "api", "batch"},
+			Teams: map[string]map[string]string{
+				"backend": {"api": "admin", "batch": "admin", "web": "push", "secret": "admin"},
+			},
+		},
+		fakegithub.Installation{
+			Id:           2,
+			Owner:        "nekaise",
+			Permissions:  map[string]string{"contents": "write", "metadata": "read"},
+			Repositories: []fakegithub.Repository{{Id: 20, Name: "web"}},
+			Teams:        map[string]map[string]string{"backend": {"web": "admin"}},
+		},
+	)
+
+	t.Setenv("GITHUB_APP_PRIVATE_KEY_DEFAULT", string(server.PrivateKey()))
+
+	service := newTestService(t, Options{AllowedBaseUrls: []string{server.URL}})
+
+	tests := []struct {
+		name           string
+		owner          string
+		mode           string
+		topic          *string
+		team           *string
+		repo           *string
+		want           []string
+		wantErrPattern string
+	}{
+		{
+			name:  "topic",
+			owner: "catnekaise",
+			mode:  api.RepositorySelectionModeByTopic,
+			topic: github.String("service"),
+			want:  []string{"web", "api"},
+		},
+		{
+			name:  "topic of repositories the installation cannot access",
+			owner: "catnekaise",
+			mode:  api.RepositorySelectionModeByTopic,
+			topic: github.String("backend"),
+			want:  []string{"api", "batch"},
+		},
+		{
+			name:  "topic intersected with requested repositories",
+			owner: "catnekaise",
+			mode:  api.RepositorySelectionModeByTopic,
+			topic: github.String("backend"),
+			repo:  github.String("Batch,web"),
+			want:  []string{"batch"},
+		},
+		{
+			name:  "topic intersected with requested repository ids",
+			owner: "catnekaise",
+			mode:  api.RepositorySelectionModeByTopic,
+			topic: github.String("backend"),
+			repo:  github.String("id:11"),
+			want:  []string{"api"},
+		},
+		{
+			name:           "topic without requested repositories",
+			owner:          "catnekaise",
+			mode:           api.RepositorySelectionModeByTopic,
+			topic:          github.String("frontend"),
+			repo:           github.String("api"),
+			wantErrPattern: `"code":"NO_REPOSITORIES_SELECTED"`,
+		},
+		{
+			name:           "topic missing",
+			owner:          "catnekaise",
+			mode:           api.RepositorySelectionModeByTopic,
+			wantErrPattern: `"code":"INVALID_REPOSITORY_SELECTION"`,
+		},
+		{
+			name:  "team owns repositories with admin access",
+			owner: "catnekaise",
+			mode:  api.RepositorySelectionModeByTeam,
+			team:  github.String("backend"),
+			want:  []string{"api", "batch"},
+		},
+		{
+			name:           "unknown team",
+			owner:          "catnekaise",
+			mode:           api.RepositorySelectionModeByTeam,
+			team:           github.String("frontend"),
+			wantErrPattern: `"code":"NO_REPOSITORIES_SELECTED"`,
+		},
+		{
+			name:           "invalid team",
+			owner:          "catnekaise",
+			mode:           api.RepositorySelectionModeByTeam,
+			team:           github.String("back end"),
+			wantErrPattern: `"code":"INVALID_REPOSITORY_SELECTION"`,
+		},
+		{
+			name:           "members not granted",
+			owner:          "nekaise",
+			mode:           api.RepositorySelectionModeByTeam,
+			team:           github.String("backend"),
+			wantErrPattern: `"code":"PERMISSIONS_NOT_GRANTED","message":"The GitHub App needs Members and Metadata read access for BY_TEAM"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := createTestInput(tt.owner, tt.repo, github.String("DYNAMIC_OWNER"), github.String(tt.mode))
+			req.TokenContext.App.BaseUrl = server.URL
+			req.TokenRequest.Topic = tt.topic
+			req.TokenRequest.Team = tt.team
+
+			before := len(server.Tokens())
+			got, err := service.handleInput(context.TODO(), req)
+
+			for _, token := range server.Tokens()[before:] {
+				if _, ok := token.Permissions["metadata"]; ok && !token.Revoked {
+					t.Errorf("handleInput() did not revoke lookup token %v", token.Token)
+				}
+			}
+
+			if tt.wantErrPattern != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErrPattern) {
+					t.Errorf("handleInput() error = %v, want %v", err, tt.wantErrPattern)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("handleInput() error = %v", err)
+			}
+
+			var names []string
+
+			for _, repository := range got.Repositories {
+				names = append(names, repository.Name)
+			}
+
+			if got.RepositorySelection != "selected" || !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("handleInput() got = %v %v, want selected %v", got.RepositorySelection, names, tt.want)
+			}
+		})
+	}
+}
+
+func TestService_lookupClient_revokeAfterCancel(t *testing.T) {
+
+	server := fakegithub.New(t, 1234, fakegithub.Installation{
+		Id:           1,
+		Owner:        "catnekaise",
+		Permissions:  map[string]string{"metadata": "read"},
+		Repositories: []fakegithub.Repository{{Id: 10, Name: "web"}},
+	})
+
+	t.Setenv("GITHUB_APP_PRIVATE_KEY_DEFAULT", string(server.PrivateKey()))
+
+	service := newTestService(t, Options{AllowedBaseUrls: []string{server.URL}})
+	app := api.App{Id: 1234, Name: "default", BaseUrl: server.URL}
+
+	baseUrl, err := service.githubBaseUrl(app)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	appKeys, err := service.appTransports.get(context.TODO(), app, baseUrl)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	_, revoke, err := service.lookupClient(ctx, appKeys[0].client, app.Id, "catnekaise", api.Permissions{Metadata: github.String("read")}, "Metadata read access")
+
+	if err != nil {
+		t.Fatalf("lookupClient() error = %v", err)
+	}
+
+	cancel()
+	revoke()
+
+	if tokens := server.Tokens(); len(tokens) != 1 || !tokens[0].Revoked {
+		t.Errorf("lookupClient() did not revoke the lookup token after the request was cancelled, tokens = %+v", tokens)
+	}
+}
@@ -0,0 +1,156 @@
+package internal
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/catnekaise/ghrawel-tokenprovider-lambda-go/pkg/api"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	serverRequestTimeout  = 30 * time.Second
+	serverShutdownTimeout = 10 * time.Second
+	serverMaxBodyBytes    = 64 << 10
+)
+
+// StartServer serves the providers of the providers file over HTTP on addr, for running the token provider
+// outside of Lambda. Requests are not authenticated, so the server has to listen on a loopback address or
+// sit behind a proxy that authenticates callers.
+func StartServer(addr string, providersFile string) {
+
+	config, err := loadConfig(os.Getenv)
+
+	logger := slog.New(logger{minLevel: config.level(), next: handler(config.level())})
+	slog.SetDefault(logger)
+
+	if err != nil {
+		slog.Error(fmt.Sprintf("ConfigurationError - %s", strings.ReplaceAll(err.Error(), "\n", "; ")))
+		os.Exit(1)
+	}
+
+	if providersFile == "" {
+		slog.Error("ConfigurationError - A providers file is required, set -config or TOKEN_PROVIDERS_FILE")
+		os.Exit(1)
+	}
+
+	config.ProvidersFile = providersFile
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	service, err := newServiceFromConfig(ctx, config)
+
+	if err != nil {
+		slog.Error(fmt.Sprintf("ConfigurationError - %s", err.Error()))
+		os.Exit(1)
+	}
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           newServerHandler(service),
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+	}
+
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), serverShutdownTimeout)
+		defer cancel()
+
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			slog.Error(fmt.Sprintf("Server shutdown - %s", err.Error()))
+		}
+	}()
+
+	slog.Info(fmt.Sprintf("Serving %v provider(s) on %s", len(service.providers), addr))
+
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error(fmt.Sprintf("Server - %s", err.Error()))
+		os.Exit(1)
+	}
+}
+
+// newServerHandler serves GET and POST requests to /{provider} the same way as requests of a Function URL.
+func newServerHandler(service *Service) http.Handler {
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			w.Header().Set("Allow", "GET, POST")
+			writeHttpResponse(w, newHttpResponse(nil, createErrorResponse(api.ErrorCodeInvalidRequest, "Method not allowed", 405)))
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, serverMaxBodyBytes))
+
+		if err != nil {
+			writeHttpResponse(w, newHttpResponse(nil, createErrorResponse(api.ErrorCodeInvalidRequest, "Request body is invalid", 400)))
+			return
+		}
+
+		query := map[string]string{}
+
+		for key := range r.URL.Query() {
+			query[key] = r.URL.Query().Get(key)
+		}
+
+		sourceIp, _, err := net.SplitHostPort(r.RemoteAddr)
+
+		if err != nil {
+			sourceIp = r.RemoteAddr
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), serverRequestTimeout)
+		defer cancel()
+
+		writeHttpResponse(w, service.serveProviderRequest(ctx, httpRequest{
+			caller: api.Caller{
+				RequestId: newRequestId(),
+				UserAgent: r.UserAgent(),
+				SourceIp:  sourceIp,
+				Path:      r.URL.Path,
+			},
+			path:          r.URL.Path,
+			query:         query,
+			authorization: r.Header.Get("Authorization"),
+			body:          string(body),
+		}))
+	})
+
+	return mux
+}
+
+func writeHttpResponse(w http.ResponseWriter, response httpResponse) {
+
+	for key, value := range response.headers {
+		w.Header().Set(key, value)
+	}
+
+	w.WriteHeader(response.statusCode)
+	io.WriteString(w, response.body)
+}
+
+func newRequestId() string {
+
+	b := make([]byte, 16)
+
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+
+	return hex.EncodeToString(b)
+}
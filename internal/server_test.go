@@ -0,0 +1,145 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_newServerHandler(t *testing.T) {
+
+	t.Setenv("GITHUB_APP_PRIVATE_KEY_DEFAULT", createTestPrivateKey(t))
+
+	github := createTestEnterpriseServer(t)
+	providersFile := filepath.Join(t.TempDir(), "providers.json")
+
+	err := os.WriteFile(providersFile, []byte(fmt.Sprintf(`{
+		"providers": [
+			{
+				"providerName": "example",
+				"permissions": {"contents": "read"},
+				"app": {"id": 1234, "name": "default", "baseUrl": %q},
+				"endpoint": {"type": "DYNAMIC_OWNER"},
+				"targetRule": {"repositorySelectionMode": "AT_LEAST_ONE"}
+			},
+			{
+				"providerName": "revoke",
+				"app": {"id": 1234, "name": "default", "baseUrl": %q},
+				"endpoint": {"type": "REVOKE"},
+				"targetRule": {"repositorySelectionMode": "ALLOW_OWNER"},
+				"owner": "catnekaise"
+			}
+		]
+	}`, github.URL, github.URL)), 0600)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := loadProviders(providersFile)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(newServerHandler(newTestService(t, Options{Providers: loaded, AllowedBaseUrls: []string{github.URL}})))
+	defer server.Close()
+
+	tests := []struct {
+		name           string
+		method         string
+		target         string
+		authorization  string
+		body           string
+		wantStatusCode int
+		wantBody       string
+	}{
+		{
+			name:           "post",
+			method:         http.MethodPost,
+			target:         "/example",
+			body:           `{"owner": "catnekaise", "repo": "example-repo"}`,
+			wantStatusCode: 200,
+			wantBody:       `"token":"ghs_example"`,
+		},
+		{
+			name:           "get",
+			method:         http.MethodGet,
+			target:         "/example?owner=catnekaise&repo=example-repo",
+			wantStatusCode: 200,
+			wantBody:       `"token":"ghs_example"`,
+		},
+		{
+			name:           "invalid owner",
+			method:         http.MethodGet,
+			target:         "/example?owner=catnekaise%23",
+			wantStatusCode: 400,
+			wantBody:       `"code":"INVALID_OWNER"`,
+		},
+		{
+			name:           "unknown provider",
+			method:         http.MethodGet,
+			target:         "/unknown?owner=catnekaise",
+			wantStatusCode: 404,
+			wantBody:       `"code":"PROVIDER_NOT_FOUND"`,
+		},
+		{
+			name:           "revoke token in authorization header",
+			method:         http.MethodPost,
+			target:         "/revoke",
+			authorization:  "Bearer ghs_example",
+			wantStatusCode: 200,
+			wantBody:       `"revoked":true`,
+		},
+		{
+			name:           "revoke token in query string",
+			method:         http.MethodGet,
+			target:         "/revoke?token=ghs_example",
+			wantStatusCode: 400,
+			wantBody:       `"code":"INVALID_REQUEST"`,
+		},
+		{
+			name:           "method not allowed",
+			method:         http.MethodDelete,
+			target:         "/example",
+			wantStatusCode: 405,
+			wantBody:       `"code":"INVALID_REQUEST"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, server.URL+tt.target, strings.NewReader(tt.body))
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+
+			res, err := http.DefaultClient.Do(req)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer res.Body.Close()
+
+			body, err := io.ReadAll(res.Body)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if res.StatusCode != tt.wantStatusCode || !strings.Contains(string(body), tt.wantBody) {
+				t.Errorf("server got = %v %s, want %v %v", res.StatusCode, body, tt.wantStatusCode, tt.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/catnekaise/ghrawel-tokenprovider-lambda-go/pkg/api"
+	"github.com/catnekaise/ghrawel-tokenprovider-lambda-go/pkg/keys"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Options are the collaborators of a Service. Collaborators left unset fall back to the ones the Lambda
+// function uses.
+type Options struct {
+	// KeyProvider supplies the private keys of the apps. When nil, a provider of SecretsStorage is created.
+	KeyProvider    keys.KeyProvider
+	SecretsStorage string
+	SecretsPrefix  string
+	// BaseUrl is the API URL of the GitHub Enterprise Server of apps without a base URL of their own.
+	BaseUrl string
+	// AllowedBaseUrls are the base URLs, besides BaseUrl, apps may set as their own base URL.
+	AllowedBaseUrls    []string
+	Transport          http.RoundTripper
+	Logger             *slog.Logger
+	Now                func() time.Time
+	PrivateKeyCacheTtl time.Duration
+	// Providers are served through a Function URL, an HTTP API, direct invocations and the server.
+	Providers map[string]api.ProviderConfig
+	// PermissionPolicy limits the permissions of each provider, when set.
+	PermissionPolicy *api.PermissionPolicy
+}
+
+// Service creates and revokes installation tokens. It holds the caches kept across warm invocations, so a
+// process should use a single Service for all requests.
+type Service struct {
+	keyProvider     keys.KeyProvider
+	baseUrl         string
+	allowedBaseUrls []string
+	transport       http.RoundTripper
+	logger          *slog.Logger
+	installations   *installationCache
+	appTransports   *appTransportCache
+	providers       map[string]api.ProviderConfig
+	policy          permissionPolicy
+}
+
+func NewService(ctx context.Context, opts Options) (*Service, error) {
+
+	keyProvider := opts.KeyProvider
+
+	if keyProvider == nil {
+
+		if !keys.Registered(opts.SecretsStorage) {
+			return nil, errors.New(fmt.Sprintf("unknown secrets storage %q", opts.SecretsStorage))
+		}
+
+		if isAwsSecretsStorage(opts.SecretsStorage) && !prefixRegex.MatchString(opts.SecretsPrefix) {
+			return nil, errors.New(fmt.Sprintf("invalid secrets prefix %q", opts.SecretsPrefix))
+		}
+
+		var err error
+
+		keyProvider, err = keys.New(ctx, opts.SecretsStorage, opts.SecretsPrefix)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	policy, err := newPermissionPolicy(opts.PermissionPolicy)
+
+	if err != nil {
+		return nil, err
+	}
+
+	now := opts.Now
+
+	if now == nil {
+		now = time.Now
+	}
+
+	transport := opts.Transport
+
+	if transport == nil {
+		transport = githubTransport.withNow(now)
+	}
+
+	logger := slog.Default()
+
+	if opts.Logger != nil {
+		logger = newContextLogger(opts.Logger)
+	}
+
+	s := &Service{
+		keyProvider:     keyProvider,
+		baseUrl:         opts.BaseUrl,
+		allowedBaseUrls: opts.AllowedBaseUrls,
+		transport:       transport,
+		logger:          logger,
+		installations:   newInstallationCache(installationCacheTtl, installationCacheNegativeTtl),
+		appTransports:   newAppTransportCache(opts.PrivateKeyCacheTtl),
+		providers:       opts.Providers,
+		policy:          policy,
+	}
+
+	s.installations.now = now
+	s.appTransports.now = now
+	s.appTransports.transport = transport
+	s.appTransports.logger = logger
+	s.appTransports.fetch = keyProvider.Keys
+
+	return s, nil
+}
+
+// Handle handles a request of the ghrawel REST API, where the mapping template supplies the TokenContext.
+func (s *Service) Handle(ctx context.Context, req api.Input) (any, error) {
+
+	return s.serve(ctx, req, restApiCaller(req))
+}
+
+// Invoke handles every payload the function accepts, see invoke.
+func (s *Service) Invoke(ctx context.Context, payload json.RawMessage) (any, error) {
+
+	return s.invoke(ctx, payload)
+}
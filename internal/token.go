@@ -8,68 +8,213 @@ import (
 	"github.com/catnekaise/ghrawel-tokenprovider-lambda-go/pkg/api"
 	"github.com/google/go-github/v60/github"
 	"net/http"
+	"strings"
 )
 
-var knownInstallations = map[int64]map[string]int64{}
+var errInstallationNotFound = errors.New("could not find installation")
+
+// installationToken is the response of the access_tokens endpoint, decoded into api.Permissions so that the
+// granted permissions can be returned as is.
+type installationToken struct {
+	Token               string               `json:"token"`
+	ExpiresAt           github.Timestamp     `json:"expires_at"`
+	Permissions         *api.Permissions     `json:"permissions,omitempty"`
+	RepositorySelection string               `json:"repository_selection,omitempty"`
+	Repositories        []*github.Repository `json:"repositories,omitempty"`
+}
 
 type installationTokenOptions struct {
-	Repositories []string         `json:"repositories,omitempty"`
-	Permissions  *api.Permissions `json:"permissions"`
+	Repositories  []string         `json:"repositories,omitempty"`
+	RepositoryIds []int64          `json:"repository_ids,omitempty"`
+	Permissions   *api.Permissions `json:"permissions"`
 }
 
-func createClient(privateKey *string, appId int64) (*github.Client, error) {
+func createClient(itr *ghinstallation.AppsTransport, baseUrl string) (*github.Client, error) {
+
+	return newGitHubClient(&http.Client{Transport: itr}, baseUrl)
+}
 
-	itr, err := ghinstallation.NewAppsTransport(http.DefaultTransport, appId, []byte(*privateKey))
+// newGitHubClient returns a client for api.github.com, or for the GitHub Enterprise Server API at baseUrl.
+// The /api/v3 suffix is added to baseUrl unless already present.
+func newGitHubClient(httpClient *http.Client, baseUrl string) (*github.Client, error) {
 
-	if err != nil {
-		return nil, err
+	client := github.NewClient(httpClient)
+
+	if baseUrl == "" {
+		return client, nil
 	}
 
-	return github.NewClient(&http.Client{Transport: itr}), nil
+	return client.WithEnterpriseURLs(baseUrl, baseUrl)
 }
 
-func findInstallation(ctx context.Context, client *github.Client, appId int64, owner string) (*int64, error) {
+// githubBaseUrl returns the base URL configured for the app, falling back to the base URL of the service. An
+// empty string means api.github.com. The base URL of an app has to be the base URL of the service or one of
+// the allowed base URLs, since JWTs signed with the private key of the app are sent to it.
+func (s *Service) githubBaseUrl(app api.App) (string, error) {
+
+	if app.BaseUrl == "" {
+		return s.baseUrl, nil
+	}
 
-	if installations, ok := knownInstallations[appId]; ok {
-		if installationId, ok2 := installations[owner]; ok2 {
-			return &installationId, nil
+	for _, allowed := range append([]string{s.baseUrl}, s.allowedBaseUrls...) {
+		if allowed != "" && normalizeBaseUrl(allowed) == normalizeBaseUrl(app.BaseUrl) {
+			return app.BaseUrl, nil
 		}
-	} else {
-		knownInstallations[appId] = map[string]int64{}
 	}
 
-	var installationId *int64
+	return "", errors.New(fmt.Sprintf("base URL %q of %s is not allowed, add it to GITHUB_ALLOWED_API_URLS", app.BaseUrl, app.Name))
+}
 
-	appInstallations, _, err := client.Apps.ListInstallations(ctx, &github.ListOptions{})
+func normalizeBaseUrl(baseUrl string) string {
 
-	if err != nil {
+	return strings.TrimSuffix(strings.TrimSuffix(strings.ToLower(baseUrl), "/"), "/api/v3")
+}
+
+// findInstallation returns the installation of the app on owner and whether the answer came from the cache.
+func (s *Service) findInstallation(ctx context.Context, client *github.Client, appId int64, owner string) (*int64, bool, error) {
+
+	baseUrl := client.BaseURL.String()
+
+	if installationId, found, ok := s.installations.get(baseUrl, appId, owner); ok {
+		if !found {
+			return nil, true, errInstallationNotFound
+		}
+
+		return &installationId, true, nil
+	}
+
+	installationId, err := s.lookupInstallation(ctx, client, owner)
+
+	if errors.Is(err, errInstallationNotFound) {
+		s.installations.setNotFound(baseUrl, appId, owner)
+		return nil, false, err
+	} else if err != nil {
+		return nil, false, err
+	}
+
+	s.installations.set(baseUrl, appId, owner, *installationId)
+
+	return installationId, false, nil
+}
+
+// lookupInstallation resolves the installation for owner using the per-owner endpoints, falling back to
+// listing every installation of the app. errInstallationNotFound is returned only when GitHub answered
+// and the app is not installed on owner, any other failure is returned as is.
+func (s *Service) lookupInstallation(ctx context.Context, client *github.Client, owner string) (*int64, error) {
+
+	installation, _, err := client.Apps.FindOrganizationInstallation(ctx, owner)
+
+	if err == nil {
+		return installation.ID, nil
+	} else if !isNotFound(err) {
 		return nil, err
 	}
 
-	for _, installation := range appInstallations {
+	installation, _, err = client.Apps.FindUserInstallation(ctx, owner)
+
+	if err == nil {
+		return installation.ID, nil
+	} else if !isNotFound(err) {
+		return nil, err
+	}
+
+	s.logger.DebugContext(ctx, fmt.Sprintf("No installation found for %q using owner endpoints, listing installations", owner))
+
+	opts := &github.ListOptions{PerPage: 100}
+
+	for {
+		appInstallations, resp, err := client.Apps.ListInstallations(ctx, opts)
 
-		if *installation.Account.Login == owner {
-			installationId = installation.ID
+		if err != nil {
+			return nil, err
+		}
+
+		for _, installation := range appInstallations {
+
+			if installation.Account != nil && strings.EqualFold(installation.Account.GetLogin(), owner) {
+				return installation.ID, nil
+			}
+		}
+
+		if resp.NextPage == 0 {
 			break
 		}
+
+		opts.Page = resp.NextPage
 	}
 
-	if installationId == nil {
-		return nil, errors.New("could not find installation")
+	return nil, errInstallationNotFound
+}
+
+func isNotFound(err error) bool {
+
+	return hasStatusCode(err, http.StatusNotFound)
+}
+
+func isUnauthorized(err error) bool {
+
+	return hasStatusCode(err, http.StatusUnauthorized)
+}
+
+func hasStatusCode(err error, statusCode int) bool {
+
+	var errorResponse *github.ErrorResponse
+
+	if errors.As(err, &errorResponse) && errorResponse.Response != nil {
+		return errorResponse.Response.StatusCode == statusCode
+	}
+
+	return false
+}
+
+// getToken creates an installation token for owner. When GitHub rejects a cached installation, which happens
+// when the app has been uninstalled or reinstalled, the installation is looked up again and the request is
+// retried once.
+func (s *Service) getToken(ctx context.Context, client *github.Client, appId int64, owner string, permissions api.Permissions, repo []string) (*installationToken, error) {
+
+	installationId, cached, err := s.findInstallation(ctx, client, appId, owner)
+
+	if err != nil {
+		return nil, err
 	}
 
-	knownInstallations[appId][owner] = *installationId
+	token, err := createInstallationToken(ctx, client, *installationId, permissions, repo)
+
+	if err != nil && cached && isNotFound(err) {
+		s.logger.InfoContext(ctx, fmt.Sprintf("Cached installation %v for %s was rejected, looking up installation again", *installationId, owner))
+		s.installations.invalidate(client.BaseURL.String(), appId, owner)
+
+		installationId, _, err = s.findInstallation(ctx, client, appId, owner)
+
+		if err != nil {
+			return nil, err
+		}
 
-	return installationId, nil
+		token, err = createInstallationToken(ctx, client, *installationId, permissions, repo)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
 }
 
-func getToken(ctx context.Context, client *github.Client, installationId *int64, permissions api.Permissions, repo []string) (*github.InstallationToken, error) {
+func createInstallationToken(ctx context.Context, client *github.Client, installationId int64, permissions api.Permissions, repo []string) (*installationToken, error) {
 
-	u := fmt.Sprintf("app/installations/%v/access_tokens", *installationId)
+	u := fmt.Sprintf("app/installations/%v/access_tokens", installationId)
 
 	body := installationTokenOptions{
-		Repositories: repo,
-		Permissions:  &permissions,
+		Permissions: &permissions,
+	}
+
+	for _, repository := range repo {
+
+		if id, ok := readRepoId(repository); ok {
+			body.RepositoryIds = append(body.RepositoryIds, id)
+		} else {
+			body.Repositories = append(body.Repositories, repository)
+		}
 	}
 
 	request, err := client.NewRequest("POST", u, body)
@@ -78,7 +223,7 @@ func getToken(ctx context.Context, client *github.Client, installationId *int64,
 		return nil, err
 	}
 
-	token := new(github.InstallationToken)
+	token := new(installationToken)
 	_, err = client.Do(ctx, request, token)
 
 	if err != nil {
@@ -87,3 +232,39 @@ func getToken(ctx context.Context, client *github.Client, installationId *int64,
 
 	return token, nil
 }
+
+// repositoryIds returns the IDs of the repositories of the token by full name, so that audit logs identify
+// repositories requested by name or ID alike.
+func repositoryIds(token *installationToken) map[string]int64 {
+
+	ids := map[string]int64{}
+
+	for _, repository := range token.Repositories {
+		ids[repository.GetFullName()] = repository.GetID()
+	}
+
+	return ids
+}
+
+func newTokenResponse(token *installationToken) *api.TokenResponse {
+
+	response := &api.TokenResponse{
+		Token:               token.Token,
+		Permissions:         token.Permissions,
+		RepositorySelection: token.RepositorySelection,
+	}
+
+	if !token.ExpiresAt.IsZero() {
+		response.ExpiresAt = &token.ExpiresAt.Time
+	}
+
+	for _, repository := range token.Repositories {
+		response.Repositories = append(response.Repositories, api.Repository{
+			Id:       repository.GetID(),
+			Name:     repository.GetName(),
+			FullName: repository.GetFullName(),
+		})
+	}
+
+	return response
+}
@@ -0,0 +1,225 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/catnekaise/ghrawel-tokenprovider-lambda-go/pkg/api"
+	"github.com/google/go-github/v60/github"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_lookupInstallation(t *testing.T) {
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/orgs/catnekaise/installation", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"id": 1}`)
+	})
+
+	mux.HandleFunc("/users/djonser/installation", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"id": 2}`)
+	})
+
+	mux.HandleFunc("/orgs/broken/installation", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	})
+
+	mux.HandleFunc("/app/installations", func(w http.ResponseWriter, r *http.Request) {
+
+		if r.URL.Query().Get("page") == "2" {
+			fmt.Fprint(w, `[{"id": 4, "account": {"login": "later-owner"}}]`)
+			return
+		}
+
+		w.Header().Set("Link", fmt.Sprintf(`<%s/app/installations?per_page=100&page=2>; rel="next"`, "http://"+r.Host))
+		fmt.Fprint(w, `[{"id": 3, "account": {"login": "first-owner"}}]`)
+	})
+
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"message": "Not Found"}`)
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client := createTestClient(t, server)
+	service := newTestService(t, Options{})
+
+	tests := []struct {
+		owner   string
+		want    int64
+		wantErr error
+	}{
+		{owner: "catnekaise", want: 1},
+		{owner: "djonser", want: 2},
+		{owner: "first-owner", want: 3},
+		{owner: "later-owner", want: 4},
+		{owner: "unknown", wantErr: errInstallationNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.owner, func(t *testing.T) {
+			got, err := service.lookupInstallation(context.TODO(), client, tt.owner)
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("lookupInstallation() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			if tt.wantErr == nil && *got != tt.want {
+				t.Errorf("lookupInstallation() got = %v, want %v", *got, tt.want)
+			}
+		})
+	}
+
+	t.Run("transport error", func(t *testing.T) {
+		_, err := service.lookupInstallation(context.TODO(), client, "broken")
+
+		if err == nil || errors.Is(err, errInstallationNotFound) {
+			t.Errorf("lookupInstallation() error = %v, want non not found error", err)
+		}
+	})
+}
+
+func createTestClient(t *testing.T, server *httptest.Server) *github.Client {
+
+	client := github.NewClient(nil)
+	baseURL, err := url.Parse(server.URL + "/")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client.BaseURL = baseURL
+
+	return client
+}
+
+func Test_getToken(t *testing.T) {
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/orgs/catnekaise/installation", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"id": 2}`)
+	})
+
+	mux.HandleFunc("/app/installations", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[]`)
+	})
+
+	mux.HandleFunc("/app/installations/2/access_tokens", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, `{"token": "ghs_example"}`)
+	})
+
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"message": "Not Found"}`)
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client := createTestClient(t, server)
+
+	t.Run("stale cached installation", func(t *testing.T) {
+
+		service := newTestService(t, Options{})
+		service.installations.set(client.BaseURL.String(), 1234, "catnekaise", 1)
+
+		token, err := service.getToken(context.TODO(), client, 1234, "catnekaise", api.Permissions{}, nil)
+
+		if err != nil {
+			t.Fatalf("getToken() error = %v", err)
+		}
+
+		if token.Token != "ghs_example" {
+			t.Errorf("getToken() got = %v, want %v", token.Token, "ghs_example")
+		}
+
+		if id, _, _ := service.installations.get(client.BaseURL.String(), 1234, "catnekaise"); id != 2 {
+			t.Errorf("installation cache got = %v, want %v", id, 2)
+		}
+	})
+
+	t.Run("not installed", func(t *testing.T) {
+
+		service := newTestService(t, Options{})
+
+		_, err := service.getToken(context.TODO(), client, 1234, "unknown", api.Permissions{}, nil)
+
+		if !errors.Is(err, errInstallationNotFound) {
+			t.Errorf("getToken() error = %v, want %v", err, errInstallationNotFound)
+		}
+
+		if _, found, ok := service.installations.get(client.BaseURL.String(), 1234, "unknown"); !ok || found {
+			t.Error("getToken() did not cache unknown owner")
+		}
+	})
+}
+
+func Test_newTokenResponse(t *testing.T) {
+
+	token := new(installationToken)
+	body := `{
+		"token": "ghs_example",
+		"expires_at": "2024-06-01T13:00:00Z",
+		"permissions": {"contents": "read", "metadata": "read"},
+		"repository_selection": "selected",
+		"repositories": [{"id": 1296269, "name": "example-repo", "full_name": "catnekaise/example-repo"}]
+	}`
+
+	if err := json.Unmarshal([]byte(body), token); err != nil {
+		t.Fatal(err)
+	}
+
+	expiresAt := time.Date(2024, 6, 1, 13, 0, 0, 0, time.UTC)
+
+	want := &api.TokenResponse{
+		Token:               "ghs_example",
+		ExpiresAt:           &expiresAt,
+		Permissions:         &api.Permissions{Contents: github.String("read"), Metadata: github.String("read")},
+		RepositorySelection: "selected",
+		Repositories:        []api.Repository{{Id: 1296269, Name: "example-repo", FullName: "catnekaise/example-repo"}},
+	}
+
+	if got := newTokenResponse(token); !reflect.DeepEqual(got, want) {
+		t.Errorf("newTokenResponse() got = %+v, want %+v", got, want)
+	}
+}
+
+func TestService_githubBaseUrl(t *testing.T) {
+
+	service := &Service{baseUrl: "https://github.example.com", allowedBaseUrls: []string{"https://ghes.example.com/api/v3"}}
+
+	tests := []struct {
+		name    string
+		app     api.App
+		want    string
+		wantErr bool
+	}{
+		{name: "base URL of service", app: api.App{Name: "default"}, want: "https://github.example.com"},
+		{name: "same as base URL of service", app: api.App{Name: "default", BaseUrl: "https://github.example.com/api/v3/"}, want: "https://github.example.com/api/v3/"},
+		{name: "allowed base URL", app: api.App{Name: "default", BaseUrl: "https://GHES.example.com"}, want: "https://GHES.example.com"},
+		{name: "base URL not allowed", app: api.App{Name: "default", BaseUrl: "https://attacker.example.com"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := service.githubBaseUrl(tt.app)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("githubBaseUrl() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("githubBaseUrl() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
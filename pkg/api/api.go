@@ -1,15 +1,53 @@
 package api
 
-import "github.com/aws/aws-lambda-go/events"
+import (
+	"github.com/aws/aws-lambda-go/events"
+	"time"
+)
 
 const (
 	RepositorySelectionModeAllowOwner = "ALLOW_OWNER"
 	RepositorySelectionModeAtLeastOne = "AT_LEAST_ONE"
+	RepositorySelectionModeByProperty = "BY_PROPERTY"
+	RepositorySelectionModeByTopic    = "BY_TOPIC"
+	RepositorySelectionModeByTeam     = "BY_TEAM"
 	EndpointTypeDefault               = "DEFAULT"
 	EndpointTypeStaticOwner           = "STATIC_OWNER"
 	EndpointTypeDynamicOwner          = "DYNAMIC_OWNER"
+	EndpointTypeRevoke                = "REVOKE"
 	SecretsStorageParameterStore      = "PARAMETER_STORE"
 	SecretsStorageSecretsManager      = "SECRETS_MANAGER"
+	SecretsStorageKms                 = "KMS"
+	SecretsStorageFile                = "FILE"
+	SecretsStorageEnv                 = "ENV"
+)
+
+// Error codes returned in the code field of error responses. Codes of 4xx errors mean the request has to be
+// changed, codes of 5xx errors mean the request may succeed when retried later or after the provider has been
+// reconfigured.
+const (
+	ErrorCodeInvalidOwner               = "INVALID_OWNER"
+	ErrorCodeInvalidRepositorySelection = "INVALID_REPOSITORY_SELECTION"
+	ErrorCodeInvalidToken               = "INVALID_TOKEN"
+	ErrorCodeInvalidRequest             = "INVALID_REQUEST"
+	ErrorCodeUnauthenticated            = "UNAUTHENTICATED"
+	ErrorCodeProviderNotFound           = "PROVIDER_NOT_FOUND"
+	ErrorCodeInstallationNotFound       = "INSTALLATION_NOT_FOUND"
+	ErrorCodeRepositoryNotAccessible    = "REPOSITORY_NOT_ACCESSIBLE"
+	ErrorCodeRepositoryNotAllowed       = "REPOSITORY_NOT_ALLOWED"
+	ErrorCodeOwnerNotAllowed            = "OWNER_NOT_ALLOWED"
+	ErrorCodeNoRepositoriesSelected     = "NO_REPOSITORIES_SELECTED"
+	ErrorCodeTooManyRepositories        = "TOO_MANY_REPOSITORIES"
+	ErrorCodePermissionsNotGranted      = "PERMISSIONS_NOT_GRANTED"
+	ErrorCodePermissionsNotAllowed      = "PERMISSIONS_NOT_ALLOWED"
+	ErrorCodeInstallationForbidden      = "INSTALLATION_FORBIDDEN"
+	ErrorCodeTokenExpired               = "TOKEN_EXPIRED"
+	ErrorCodeRateLimited                = "RATE_LIMITED"
+	ErrorCodeGitHubUnavailable          = "GITHUB_UNAVAILABLE"
+	ErrorCodeRetriesExhausted           = "RETRIES_EXHAUSTED"
+	ErrorCodePrivateKey                 = "PRIVATE_KEY_ERROR"
+	ErrorCodeConfiguration              = "CONFIGURATION_ERROR"
+	ErrorCodeInternal                   = "INTERNAL_ERROR"
 )
 
 type Permissions struct {
@@ -66,6 +104,12 @@ type Permissions struct {
 type TokenRequest struct {
 	Owner string  `json:"owner"`
 	Repo  *string `json:"repo"`
+	// Token is the previously issued token to revoke when Endpoint.Type is EndpointTypeRevoke.
+	Token *string `json:"token,omitempty"`
+	// Topic and Team select the repositories of RepositorySelectionModeByTopic and RepositorySelectionModeByTeam,
+	// where Repo optionally narrows the selection further.
+	Topic *string `json:"topic,omitempty"`
+	Team  *string `json:"team,omitempty"`
 }
 
 type TokenContext struct {
@@ -74,11 +118,16 @@ type TokenContext struct {
 	App          App         `json:"app"`
 	Endpoint     Endpoint    `json:"endpoint"`
 	TargetRule   TargetRule  `json:"targetRule"`
+	// AllowedOwners restricts the owners tokens can be created for, which matters for EndpointTypeDynamicOwner
+	// where the caller chooses the owner. Patterns are matched like the repository patterns of TargetRule.
+	AllowedOwners []string `json:"allowedOwners,omitempty"`
 }
 
 type App struct {
 	Id   int64  `json:"id"`
 	Name string `json:"name"`
+	// BaseUrl is the API URL of the GitHub Enterprise Server the app is registered on.
+	BaseUrl string `json:"baseUrl,omitempty"`
 }
 
 type Endpoint struct {
@@ -87,6 +136,23 @@ type Endpoint struct {
 
 type TargetRule struct {
 	RepositorySelectionMode string `json:"repositorySelectionMode"`
+	// AllowRepositories and DenyRepositories restrict the repositories tokens can be created for. Each pattern
+	// is either a glob such as infra-*, or a regular expression between slashes such as /^infra-[0-9]+$/.
+	// When AllowRepositories is set a repository has to match one of its patterns, and a repository matching
+	// any pattern of DenyRepositories is rejected.
+	AllowRepositories []string `json:"allowRepositories,omitempty"`
+	DenyRepositories  []string `json:"denyRepositories,omitempty"`
+	// RepositoryIds pins repositories by ID, which unlike names survive renames. Together with AllowRepositories
+	// it forms the allowlist of the target rule. Requests select repositories by ID with values such as id:123 in Repo.
+	RepositoryIds []int64 `json:"repositoryIds,omitempty"`
+	// Properties select the repositories of RepositorySelectionModeByProperty. A repository is selected when,
+	// for every filter, its custom property has one of the values of the filter.
+	Properties []PropertyFilter `json:"properties,omitempty"`
+}
+
+type PropertyFilter struct {
+	Name   string   `json:"name"`
+	Values []string `json:"values"`
 }
 
 type Input struct {
@@ -95,6 +161,95 @@ type Input struct {
 	TokenContext TokenContext `json:"tokenContext"`
 }
 
+// Caller is the identity of the caller, normalised from whichever payload the function was invoked with.
+type Caller struct {
+	RequestId                     string `json:"requestId"`
+	UserArn                       string `json:"userArn"`
+	User                          string `json:"user"`
+	AccountId                     string `json:"accountId,omitempty"`
+	UserAgent                     string `json:"userAgent,omitempty"`
+	SourceIp                      string `json:"sourceIp,omitempty"`
+	Path                          string `json:"path,omitempty"`
+	CognitoIdentityPoolId         string `json:"cognitoIdentityPoolId,omitempty"`
+	CognitoIdentityId             string `json:"cognitoIdentityId,omitempty"`
+	CognitoAuthenticationProvider string `json:"cognitoAuthenticationProvider,omitempty"`
+	CognitoAuthenticationType     string `json:"cognitoAuthenticationType,omitempty"`
+}
+
+// ProvidersConfig describes the token providers served by the server, through a Lambda Function URL or an API
+// Gateway HTTP API and by direct invocations, where there is no mapping template supplying the TokenContext.
+type ProvidersConfig struct {
+	Providers []ProviderConfig `json:"providers"`
+}
+
+type ProviderConfig struct {
+	TokenContext
+	// Owner is the owner tokens are created for when Endpoint.Type is EndpointTypeStaticOwner.
+	Owner string `json:"owner,omitempty"`
+}
+
+// InvokeVersion is the version of the InvokeRequest and InvokeResponse contract of direct invocations.
+const InvokeVersion = "1"
+
+// InvokeRequest is the payload of a direct lambda:Invoke, made for example by Step Functions, another function
+// or CodeBuild. Provider names a provider of the providers file, which supplies the TokenContext.
+type InvokeRequest struct {
+	Version  string `json:"version"`
+	Provider string `json:"provider"`
+	TokenRequest
+}
+
+// InvokeResponse is the result of a direct invocation. Failures are returned in Error rather than as a
+// function error, so exactly one of Token, Revoke and Error is set.
+type InvokeResponse struct {
+	Version string          `json:"version"`
+	Token   *TokenResponse  `json:"token,omitempty"`
+	Revoke  *RevokeResponse `json:"revoke,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+type Error struct {
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+	StatusCode int    `json:"statusCode"`
+	// RetryAfter is the number of seconds to wait before retrying, when known.
+	RetryAfter int `json:"retryAfter,omitempty"`
+}
+
+// PermissionPolicy limits the permissions the tokens of each provider can be created with, regardless of the
+// permissions in the TokenContext.
+type PermissionPolicy struct {
+	Providers []ProviderPermissionPolicy `json:"providers"`
+}
+
+type ProviderPermissionPolicy struct {
+	// ProviderName is the TokenContext.ProviderName the policy applies to, or * for every provider.
+	ProviderName string `json:"providerName"`
+	// MaxPermissions is the highest access of each permission that may be requested. When set, permissions
+	// left out may not be requested at all and requests have to name their permissions.
+	MaxPermissions *Permissions `json:"maxPermissions,omitempty"`
+	// ForbiddenPermissions are never granted at the given access or higher, read forbids a permission entirely.
+	ForbiddenPermissions *Permissions `json:"forbiddenPermissions,omitempty"`
+}
+
+type TokenResponse struct {
+	Token               string       `json:"token"`
+	ExpiresAt           *time.Time   `json:"expiresAt,omitempty"`
+	Permissions         *Permissions `json:"permissions,omitempty"`
+	RepositorySelection string       `json:"repositorySelection,omitempty"`
+	Repositories        []Repository `json:"repositories,omitempty"`
+}
+
+type RevokeResponse struct {
+	Revoked bool `json:"revoked"`
+}
+
+type Repository struct {
+	Id       int64  `json:"id"`
+	Name     string `json:"name"`
+	FullName string `json:"fullName"`
+}
+
 func IsOwnerEndpoint(value string) bool {
 	return value == EndpointTypeDynamicOwner || value == EndpointTypeStaticOwner
 }
@@ -0,0 +1,606 @@
+// Package fakegithub is an httptest based fake of the GitHub App endpoints the token provider calls, for
+// testing the whole token path offline. Requests made as the app have to carry a JWT signed with the private
+// key of the fake, and tokens are only created for repositories and permissions the installation has.
+package fakegithub
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"github.com/golang-jwt/jwt/v4"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TokenTtl is how long tokens created by the fake are valid.
+const TokenTtl = time.Hour
+
+var accessLevels = map[string]int{"read": 1, "write": 2, "admin": 3}
+
+// Repository is a repository of an installation, with its topics and the values of its custom properties by
+// name. The value of a property is a string, or a []string for a multi select property.
+type Repository struct {
+	Id         int64
+	Name       string
+	Topics     []string
+	Properties map[string]any
+}
+
+// Installation is an installation of the app on Owner, which owns Repositories. When Selected is nil the
+// installation has access to all repositories of Owner, otherwise only to the repositories named. Teams holds
+// the access of each team by team slug and repository name, such as admin, push or pull.
+type Installation struct {
+	Id           int64
+	Owner        string
+	User         bool
+	Permissions  map[string]string
+	Repositories []Repository
+	Selected     []string
+	Teams        map[string]map[string]string
+}
+
+// Token is a token created by the fake, with the repositories and permissions it was granted.
+type Token struct {
+	Token          string
+	InstallationId int64
+	Repositories   []string
+	Permissions    map[string]string
+	ExpiresAt      time.Time
+	Revoked        bool
+}
+
+type Server struct {
+	*httptest.Server
+	AppId int64
+
+	key           *rsa.PrivateKey
+	mu            sync.Mutex
+	installations []Installation
+	tokens        []*Token
+	now           func() time.Time
+}
+
+// New starts a fake for the app with the given installations. It serves the API both at the root, like
+// api.github.com, and at /api/v3/, like a GitHub Enterprise Server, and is closed when the test ends.
+func New(t testing.TB, appId int64, installations ...Installation) *Server {
+
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{
+		AppId:         appId,
+		key:           key,
+		installations: installations,
+		now:           time.Now,
+	}
+
+	api := http.NewServeMux()
+	api.HandleFunc("/orgs/", s.orgs)
+	api.HandleFunc("/users/", s.app(s.getInstallation(true)))
+	api.HandleFunc("/app/installations", s.app(s.listInstallations))
+	api.HandleFunc("/app/installations/", s.app(s.createToken))
+	api.HandleFunc("/installation/token", s.revokeToken)
+	api.HandleFunc("/installation/repositories", s.installation(s.listRepositories))
+
+	mux := http.NewServeMux()
+	mux.Handle("/api/v3/", http.StripPrefix("/api/v3", api))
+	mux.Handle("/", api)
+
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+// PrivateKey returns the PEM encoded private key the fake verifies JWTs of the app with.
+func (s *Server) PrivateKey() []byte {
+
+	return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(s.key)})
+}
+
+// Tokens returns the tokens created so far, oldest first.
+func (s *Server) Tokens() []Token {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make([]Token, 0, len(s.tokens))
+
+	for _, token := range s.tokens {
+		result = append(result, *token)
+	}
+
+	return result
+}
+
+// app only passes on requests authenticated with a valid JWT of the app.
+func (s *Server) app(next http.HandlerFunc) http.HandlerFunc {
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		raw, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+		if !ok {
+			writeMessage(w, http.StatusUnauthorized, "Requires authentication")
+			return
+		}
+
+		claims := &jwt.RegisteredClaims{}
+
+		_, err := jwt.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+
+			if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+				return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+			}
+
+			return &s.key.PublicKey, nil
+		})
+
+		if err != nil || claims.Issuer != strconv.FormatInt(s.AppId, 10) {
+			writeMessage(w, http.StatusUnauthorized, "A JSON web token could not be decoded")
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// installation only passes on requests authenticated with a valid token of an installation.
+func (s *Server) installation(next func(http.ResponseWriter, *http.Request, *Installation, *Token)) http.HandlerFunc {
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		raw, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		for _, token := range s.tokens {
+
+			if token.Token != raw || token.Revoked || !s.now().Before(token.ExpiresAt) {
+				continue
+			}
+
+			for i := range s.installations {
+				if s.installations[i].Id == token.InstallationId {
+					next(w, r, &s.installations[i], token)
+					return
+				}
+			}
+		}
+
+		writeMessage(w, http.StatusUnauthorized, "Bad credentials")
+	}
+}
+
+func (s *Server) orgs(w http.ResponseWriter, r *http.Request) {
+
+	if strings.HasSuffix(r.URL.Path, "/properties/values") {
+		s.installation(s.listPropertyValues)(w, r)
+		return
+	}
+
+	if strings.Contains(r.URL.Path, "/teams/") {
+		s.installation(s.listTeamRepositories)(w, r)
+		return
+	}
+
+	s.app(s.getInstallation(false))(w, r)
+}
+
+func (s *Server) getInstallation(user bool) http.HandlerFunc {
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		owner, ok := strings.CutSuffix(strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)[1], "/installation")
+
+		if r.Method != http.MethodGet || !ok {
+			writeMessage(w, http.StatusNotFound, "Not Found")
+			return
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		for _, installation := range s.installations {
+			if installation.User == user && strings.EqualFold(installation.Owner, owner) {
+				writeJson(w, http.StatusOK, installationJson(installation))
+				return
+			}
+		}
+
+		writeMessage(w, http.StatusNotFound, "Not Found")
+	}
+}
+
+func (s *Server) listInstallations(w http.ResponseWriter, r *http.Request) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := []any{}
+	start, end := paginate(w, r, len(s.installations))
+
+	for i := start; i < end; i++ {
+		result = append(result, installationJson(s.installations[i]))
+	}
+
+	writeJson(w, http.StatusOK, result)
+}
+
+// listPropertyValues lists the custom property values of the repositories the installation can access.
+func (s *Server) listPropertyValues(w http.ResponseWriter, r *http.Request, installation *Installation, token *Token) {
+
+	owner, _ := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/orgs/"), "/properties/values")
+
+	if r.Method != http.MethodGet || installation.User || !strings.EqualFold(installation.Owner, owner) {
+		writeMessage(w, http.StatusNotFound, "Not Found")
+		return
+	}
+
+	if _, ok := token.Permissions["organization_custom_properties"]; !ok {
+		writeMessage(w, http.StatusForbidden, "Resource not accessible by integration")
+		return
+	}
+
+	repositories := accessibleRepositories(installation)
+	result := []any{}
+	start, end := paginate(w, r, len(repositories))
+
+	for _, repository := range repositories[start:end] {
+
+		properties := []any{}
+
+		for _, name := range sortedKeys(repository.Properties) {
+			properties = append(properties, map[string]any{"property_name": name, "value": repository.Properties[name]})
+		}
+
+		result = append(result, map[string]any{
+			"repository_id":        repository.Id,
+			"repository_name":      repository.Name,
+			"repository_full_name": fmt.Sprintf("%s/%s", installation.Owner, repository.Name),
+			"properties":           properties,
+		})
+	}
+
+	writeJson(w, http.StatusOK, result)
+}
+
+// listRepositories lists the repositories the installation can access.
+func (s *Server) listRepositories(w http.ResponseWriter, r *http.Request, installation *Installation, token *Token) {
+
+	if r.Method != http.MethodGet {
+		writeMessage(w, http.StatusNotFound, "Not Found")
+		return
+	}
+
+	repositories := accessibleRepositories(installation)
+	result := []any{}
+	start, end := paginate(w, r, len(repositories))
+
+	for _, repository := range repositories[start:end] {
+		result = append(result, repositoryJson(installation, repository))
+	}
+
+	writeJson(w, http.StatusOK, map[string]any{"total_count": len(repositories), "repositories": result})
+}
+
+// listTeamRepositories lists the repositories a team of the organization has access to, with the access.
+func (s *Server) listTeamRepositories(w http.ResponseWriter, r *http.Request, installation *Installation, token *Token) {
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/orgs/"), "/")
+
+	if r.Method != http.MethodGet || len(parts) != 4 || parts[1] != "teams" || parts[3] != "repos" || installation.User || !strings.EqualFold(installation.Owner, parts[0]) {
+		writeMessage(w, http.StatusNotFound, "Not Found")
+		return
+	}
+
+	if _, ok := token.Permissions["members"]; !ok {
+		writeMessage(w, http.StatusForbidden, "Resource not accessible by integration")
+		return
+	}
+
+	access, ok := installation.Teams[parts[2]]
+
+	if !ok {
+		writeMessage(w, http.StatusNotFound, "Not Found")
+		return
+	}
+
+	var repositories []Repository
+
+	for _, repository := range accessibleRepositories(installation) {
+		if _, ok := access[repository.Name]; ok {
+			repositories = append(repositories, repository)
+		}
+	}
+
+	result := []any{}
+	start, end := paginate(w, r, len(repositories))
+
+	for _, repository := range repositories[start:end] {
+
+		role := access[repository.Name]
+		value := repositoryJson(installation, repository)
+		value["permissions"] = map[string]bool{"admin": role == "admin", "push": role == "admin" || role == "push", "pull": true}
+		result = append(result, value)
+	}
+
+	writeJson(w, http.StatusOK, result)
+}
+
+// paginate returns the range of the requested page of total items, and links the next page if there is one.
+func paginate(w http.ResponseWriter, r *http.Request, total int) (int, int) {
+
+	perPage, err := strconv.Atoi(r.URL.Query().Get("per_page"))
+
+	if err != nil || perPage <= 0 {
+		perPage = 30
+	}
+
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+
+	if err != nil || page <= 0 {
+		page = 1
+	}
+
+	if page*perPage < total {
+		w.Header().Set("Link", fmt.Sprintf(`<http://%s%s?per_page=%v&page=%v>; rel="next"`, r.Host, r.URL.Path, perPage, page+1))
+	}
+
+	return min((page-1)*perPage, total), min(page*perPage, total)
+}
+
+func (s *Server) createToken(w http.ResponseWriter, r *http.Request) {
+
+	id, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/app/installations/"), "/access_tokens")
+	installationId, err := strconv.ParseInt(id, 10, 64)
+
+	if r.Method != http.MethodPost || !ok || err != nil {
+		writeMessage(w, http.StatusNotFound, "Not Found")
+		return
+	}
+
+	var body struct {
+		Repositories  []string          `json:"repositories"`
+		RepositoryIds []int64           `json:"repository_ids"`
+		Permissions   map[string]string `json:"permissions"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeMessage(w, http.StatusBadRequest, "Problems parsing JSON")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var installation *Installation
+
+	for i := range s.installations {
+		if s.installations[i].Id == installationId {
+			installation = &s.installations[i]
+		}
+	}
+
+	if installation == nil {
+		writeMessage(w, http.StatusNotFound, "Not Found")
+		return
+	}
+
+	names := append([]string{}, body.Repositories...)
+
+	for _, id := range body.RepositoryIds {
+		for _, repository := range installation.Repositories {
+			if repository.Id == id {
+				names = append(names, repository.Name)
+			}
+		}
+	}
+
+	if len(names) != len(body.Repositories)+len(body.RepositoryIds) {
+		writeMessage(w, http.StatusUnprocessableEntity, "There is at least one repository that does not exist or is not accessible to the parent installation.")
+		return
+	}
+
+	repositories := make([]Repository, 0, len(names))
+
+	for _, name := range names {
+
+		repository, ok := accessibleRepository(installation, name)
+
+		if !ok {
+			writeMessage(w, http.StatusUnprocessableEntity, "There is at least one repository that does not exist or is not accessible to the parent installation.")
+			return
+		}
+
+		repositories = append(repositories, repository)
+	}
+
+	permissions := body.Permissions
+
+	if len(permissions) == 0 {
+		permissions = installation.Permissions
+	}
+
+	for name, access := range permissions {
+
+		level, ok := accessLevels[access]
+
+		if !ok || level > accessLevels[installation.Permissions[name]] {
+			writeMessage(w, http.StatusUnprocessableEntity, "The permissions requested are not granted to this installation.")
+			return
+		}
+	}
+
+	token := &Token{
+		Token:          "ghs_" + randomHex(),
+		InstallationId: installationId,
+		Repositories:   names,
+		Permissions:    permissions,
+		ExpiresAt:      s.now().Add(TokenTtl).Truncate(time.Second).UTC(),
+	}
+
+	s.tokens = append(s.tokens, token)
+
+	selection := "selected"
+
+	if len(repositories) == 0 {
+
+		if installation.Selected == nil {
+			selection = "all"
+		}
+
+		repositories = accessibleRepositories(installation)
+	}
+
+	repositoriesJson := make([]any, 0, len(repositories))
+
+	for _, repository := range repositories {
+		repositoriesJson = append(repositoriesJson, repositoryJson(installation, repository))
+	}
+
+	writeJson(w, http.StatusCreated, map[string]any{
+		"token":                token.Token,
+		"expires_at":           token.ExpiresAt.Format(time.RFC3339),
+		"permissions":          permissions,
+		"repository_selection": selection,
+		"repositories":         repositoriesJson,
+	})
+}
+
+func (s *Server) revokeToken(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodDelete {
+		writeMessage(w, http.StatusNotFound, "Not Found")
+		return
+	}
+
+	raw, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, token := range s.tokens {
+		if token.Token == raw && !token.Revoked && s.now().Before(token.ExpiresAt) {
+			token.Revoked = true
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+
+	writeMessage(w, http.StatusUnauthorized, "Bad credentials")
+}
+
+func accessibleRepository(installation *Installation, name string) (Repository, bool) {
+
+	for _, repository := range installation.Repositories {
+
+		if !strings.EqualFold(repository.Name, name) {
+			continue
+		}
+
+		if installation.Selected == nil {
+			return repository, true
+		}
+
+		for _, selected := range installation.Selected {
+			if strings.EqualFold(selected, name) {
+				return repository, true
+			}
+		}
+	}
+
+	return Repository{}, false
+}
+
+func accessibleRepositories(installation *Installation) []Repository {
+
+	var repositories []Repository
+
+	for _, repository := range installation.Repositories {
+		if _, ok := accessibleRepository(installation, repository.Name); ok {
+			repositories = append(repositories, repository)
+		}
+	}
+
+	return repositories
+}
+
+func installationJson(installation Installation) map[string]any {
+
+	accountType := "Organization"
+
+	if installation.User {
+		accountType = "User"
+	}
+
+	return map[string]any{
+		"id":          installation.Id,
+		"account":     map[string]any{"login": installation.Owner, "type": accountType},
+		"permissions": installation.Permissions,
+	}
+}
+
+func repositoryJson(installation *Installation, repository Repository) map[string]any {
+
+	value := map[string]any{
+		"id":        repository.Id,
+		"name":      repository.Name,
+		"full_name": fmt.Sprintf("%s/%s", installation.Owner, repository.Name),
+	}
+
+	if len(repository.Topics) > 0 {
+		value["topics"] = repository.Topics
+	}
+
+	return value
+}
+
+func sortedKeys[V any](values map[string]V) []string {
+
+	names := make([]string, 0, len(values))
+
+	for name := range values {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+func writeJson(w http.ResponseWriter, statusCode int, value any) {
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_ = json.NewEncoder(w).Encode(value)
+}
+
+func writeMessage(w http.ResponseWriter, statusCode int, message string) {
+
+	writeJson(w, statusCode, map[string]string{"message": message})
+}
+
+func randomHex() string {
+
+	b := make([]byte, 18)
+	_, _ = rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
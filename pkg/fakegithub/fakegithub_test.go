@@ -0,0 +1,139 @@
+package fakegithub
+
+import (
+	"fmt"
+	"github.com/golang-jwt/jwt/v4"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServer_app(t *testing.T) {
+
+	server := New(t, 1234, Installation{Id: 1, Owner: "catnekaise"})
+	other := New(t, 1234)
+
+	tests := []struct {
+		name           string
+		authorization  func() string
+		wantStatusCode int
+	}{
+		{
+			name:           "jwt of app",
+			authorization:  func() string { return "Bearer " + signTestJwt(t, server, "1234") },
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "jwt of other app",
+			authorization:  func() string { return "Bearer " + signTestJwt(t, server, "4321") },
+			wantStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:           "jwt signed with other key",
+			authorization:  func() string { return "Bearer " + signTestJwt(t, other, "1234") },
+			wantStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:           "no jwt",
+			authorization:  func() string { return "" },
+			wantStatusCode: http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, prefix := range []string{"", "/api/v3"} {
+
+				req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s%s/orgs/catnekaise/installation", server.URL, prefix), nil)
+
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				req.Header.Set("Authorization", tt.authorization())
+
+				res, err := http.DefaultClient.Do(req)
+
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				res.Body.Close()
+
+				if res.StatusCode != tt.wantStatusCode {
+					t.Errorf("GET %s/orgs/catnekaise/installation got = %v, want %v", prefix, res.StatusCode, tt.wantStatusCode)
+				}
+			}
+		})
+	}
+}
+
+func TestServer_createToken(t *testing.T) {
+
+	server := New(t, 1234, Installation{
+		Id:           1,
+		Owner:        "catnekaise",
+		Permissions:  map[string]string{"contents": "write", "metadata": "read"},
+		Repositories: []Repository{{Id: 10, Name: "example-repo"}, {Id: 11, Name: "other-repo"}},
+		Selected:     []string{"example-repo"},
+	})
+
+	tests := []struct {
+		name           string
+		body           string
+		wantStatusCode int
+	}{
+		{name: "selected repository", body: `{"repositories": ["example-repo"], "permissions": {"contents": "read"}}`, wantStatusCode: http.StatusCreated},
+		{name: "all permissions of installation", body: `{}`, wantStatusCode: http.StatusCreated},
+		{name: "repository not selected", body: `{"repositories": ["other-repo"]}`, wantStatusCode: http.StatusUnprocessableEntity},
+		{name: "unknown repository", body: `{"repositories": ["unknown"]}`, wantStatusCode: http.StatusUnprocessableEntity},
+		{name: "selected repository id", body: `{"repository_ids": [10], "permissions": {"contents": "read"}}`, wantStatusCode: http.StatusCreated},
+		{name: "unknown repository id", body: `{"repository_ids": [12]}`, wantStatusCode: http.StatusUnprocessableEntity},
+		{name: "permission not granted", body: `{"permissions": {"issues": "read"}}`, wantStatusCode: http.StatusUnprocessableEntity},
+		{name: "higher access than granted", body: `{"permissions": {"contents": "admin"}}`, wantStatusCode: http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, server.URL+"/app/installations/1/access_tokens", strings.NewReader(tt.body))
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req.Header.Set("Authorization", "Bearer "+signTestJwt(t, server, "1234"))
+
+			res, err := http.DefaultClient.Do(req)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			res.Body.Close()
+
+			if res.StatusCode != tt.wantStatusCode {
+				t.Errorf("POST access_tokens got = %v, want %v", res.StatusCode, tt.wantStatusCode)
+			}
+		})
+	}
+
+	if got := len(server.Tokens()); got != 3 {
+		t.Errorf("Tokens() got = %v, want %v", got, 3)
+	}
+}
+
+func signTestJwt(t *testing.T, server *Server, issuer string) string {
+
+	claims := jwt.RegisteredClaims{
+		IssuedAt:  jwt.NewNumericDate(time.Now().Add(-30 * time.Second)),
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		Issuer:    issuer,
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(server.key)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token
+}
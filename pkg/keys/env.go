@@ -0,0 +1,68 @@
+package keys
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+const DefaultEnvPrefix = "GITHUB_APP_PRIVATE_KEY_"
+
+var envNameRegex = regexp.MustCompile(`[^A-Z0-9]`)
+
+// Env reads a PEM encoded key, or the base64 encoding of one, from the environment variable <prefix><APP_NAME>,
+// where APP_NAME is the app name in upper case with every character other than a letter or digit replaced by
+// an underscore.
+type Env struct {
+	prefix string
+}
+
+// NewEnv returns an Env reading variables named with prefix, or DefaultEnvPrefix when prefix is empty.
+func NewEnv(prefix string) *Env {
+
+	if prefix == "" {
+		prefix = DefaultEnvPrefix
+	}
+
+	return &Env{prefix: prefix}
+}
+
+func (e *Env) Keys(ctx context.Context, appName string) ([]Key, error) {
+
+	name := e.VariableName(appName)
+	value, ok := os.LookupEnv(name)
+
+	if !ok || value == "" {
+		return nil, errors.New(fmt.Sprintf("environment variable %s is not set", name))
+	}
+
+	pem := []byte(value)
+
+	if !bytes.Contains(pem, []byte("-----BEGIN")) {
+
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("environment variable %s is neither PEM nor base64 encoded", name))
+		}
+
+		pem = decoded
+	}
+
+	sum := sha256.Sum256(pem)
+
+	return []Key{{Id: DefaultKeyId, PEM: pem, Version: hex.EncodeToString(sum[:8])}}, nil
+}
+
+// VariableName returns the name of the environment variable holding the key of the app.
+func (e *Env) VariableName(appName string) string {
+
+	return e.prefix + envNameRegex.ReplaceAllString(strings.ToUpper(appName), "_")
+}
@@ -0,0 +1,105 @@
+package keys
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const pemExtension = ".pem"
+
+// fileAppNameRegex only matches app names that are a single path element, never "." or "..".
+var fileAppNameRegex = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// File reads PEM files from a directory, either <dir>/<appName>.pem holding a single key or
+// <dir>/<appName>/<keyId>.pem holding several keys, ordered by modification time newest first.
+type File struct {
+	dir string
+}
+
+func NewFile(dir string) (*File, error) {
+
+	info, err := os.Stat(dir)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.IsDir() {
+		return nil, errors.New(fmt.Sprintf("%q is not a directory", dir))
+	}
+
+	return &File{dir: dir}, nil
+}
+
+func (f *File) Keys(ctx context.Context, appName string) ([]Key, error) {
+
+	if !fileAppNameRegex.MatchString(appName) {
+		return nil, errors.New(fmt.Sprintf("invalid app name %q", appName))
+	}
+
+	entries, err := os.ReadDir(filepath.Join(f.dir, appName))
+
+	if errors.Is(err, os.ErrNotExist) {
+		key, err := readKeyFile(filepath.Join(f.dir, appName+pemExtension), DefaultKeyId)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return []Key{*key}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var keys []Key
+
+	for _, entry := range entries {
+
+		if entry.IsDir() || filepath.Ext(entry.Name()) != pemExtension {
+			continue
+		}
+
+		key, err := readKeyFile(filepath.Join(f.dir, appName, entry.Name()), strings.TrimSuffix(entry.Name(), pemExtension))
+
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, *key)
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New(fmt.Sprintf("no %s files found for %s", pemExtension, appName))
+	}
+
+	sort.SliceStable(keys, func(i, j int) bool {
+		return keys[i].Version > keys[j].Version
+	})
+
+	return keys, nil
+}
+
+// readKeyFile reads a key using the modification time of the file, zero padded so that versions sort in
+// the same order as the modification times, as version.
+func readKeyFile(name string, id string) (*Key, error) {
+
+	info, err := os.Stat(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := os.ReadFile(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Key{Id: id, PEM: value, Version: fmt.Sprintf("%020d", info.ModTime().UnixNano())}, nil
+}
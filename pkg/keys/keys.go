@@ -0,0 +1,109 @@
+// Package keys provides the private keys used to sign GitHub App JWTs. Storages are registered by name and
+// selected with SECRETS_STORAGE, custom storages can be added with Register.
+package keys
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/bradleyfalzon/ghinstallation/v2"
+	"github.com/catnekaise/ghrawel-tokenprovider-lambda-go/pkg/api"
+	"sort"
+	"sync"
+)
+
+// DefaultKeyId is the id of a key when the storage holds a single key for an app.
+const DefaultKeyId = "default"
+
+// Key is a private key of a GitHub App. Either PEM or Signer is set, a Signer is used when the key material
+// is not available to the provider, for example when it is held in KMS.
+type Key struct {
+	// Id identifies the key among the keys of the app and is logged when the key is used.
+	Id string
+	// Version changes whenever the key material behind Id changes.
+	Version string
+	PEM     []byte
+	Signer  ghinstallation.Signer
+}
+
+// KeyProvider returns the active private keys of an app, newest first.
+type KeyProvider interface {
+	Keys(ctx context.Context, appName string) ([]Key, error)
+}
+
+// Factory creates a KeyProvider for the value of SECRETS_PREFIX.
+type Factory func(ctx context.Context, prefix string) (KeyProvider, error)
+
+var (
+	mu        sync.RWMutex
+	factories = map[string]Factory{}
+)
+
+func init() {
+	Register(api.SecretsStorageParameterStore, func(ctx context.Context, prefix string) (KeyProvider, error) {
+		return NewParameterStore(nil, prefix), nil
+	})
+	Register(api.SecretsStorageSecretsManager, func(ctx context.Context, prefix string) (KeyProvider, error) {
+		return NewSecretsManager(nil, prefix), nil
+	})
+	Register(api.SecretsStorageKms, func(ctx context.Context, prefix string) (KeyProvider, error) {
+		return NewKms(nil, prefix), nil
+	})
+	Register(api.SecretsStorageFile, func(ctx context.Context, prefix string) (KeyProvider, error) {
+		return NewFile(prefix)
+	})
+	Register(api.SecretsStorageEnv, func(ctx context.Context, prefix string) (KeyProvider, error) {
+		return NewEnv(prefix), nil
+	})
+}
+
+// Register makes a storage available by name, registering an existing name replaces it.
+func Register(storage string, factory Factory) {
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	factories[storage] = factory
+}
+
+// Registered reports whether a storage with the name has been registered.
+func Registered(storage string) bool {
+
+	mu.RLock()
+	defer mu.RUnlock()
+
+	_, ok := factories[storage]
+
+	return ok
+}
+
+// Storages returns the names of all registered storages.
+func Storages() []string {
+
+	mu.RLock()
+	defer mu.RUnlock()
+
+	storages := make([]string, 0, len(factories))
+
+	for storage := range factories {
+		storages = append(storages, storage)
+	}
+
+	sort.Strings(storages)
+
+	return storages
+}
+
+// New creates the KeyProvider of a registered storage.
+func New(ctx context.Context, storage string, prefix string) (KeyProvider, error) {
+
+	mu.RLock()
+	factory, ok := factories[storage]
+	mu.RUnlock()
+
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("Unknown storage type %q", storage))
+	}
+
+	return factory(ctx, prefix)
+}
@@ -0,0 +1,132 @@
+package keys
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/golang-jwt/jwt/v4"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const kmsSignTimeout = 10 * time.Second
+
+type KmsAPI interface {
+	DescribeKey(ctx context.Context, params *kms.DescribeKeyInput, optFns ...func(*kms.Options)) (*kms.DescribeKeyOutput, error)
+	Sign(ctx context.Context, params *kms.SignInput, optFns ...func(*kms.Options)) (*kms.SignOutput, error)
+}
+
+// Kms resolves the asymmetric KMS key targeted by the alias alias<prefix>/<appName> and signs app JWTs with
+// it, so that the private key never leaves KMS.
+type Kms struct {
+	mu     sync.Mutex
+	client KmsAPI
+	prefix string
+}
+
+type kmsSigner struct {
+	client KmsAPI
+	keyId  string
+}
+
+func (s *kmsSigner) Sign(claims jwt.Claims) (string, error) {
+
+	signingString, err := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SigningString()
+
+	if err != nil {
+		return "", err
+	}
+
+	digest := sha256.Sum256([]byte(signingString))
+
+	ctx, cancel := context.WithTimeout(context.Background(), kmsSignTimeout)
+	defer cancel()
+
+	output, err := s.client.Sign(ctx, &kms.SignInput{
+		KeyId:            aws.String(s.keyId),
+		Message:          digest[:],
+		MessageType:      types.MessageTypeDigest,
+		SigningAlgorithm: types.SigningAlgorithmSpecRsassaPkcs1V15Sha256,
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	return strings.Join([]string{signingString, jwt.EncodeSegment(output.Signature)}, "."), nil
+}
+
+// NewKms returns a Kms using client, or a client created from the default AWS configuration on first use
+// when client is nil.
+func NewKms(client KmsAPI, prefix string) *Kms {
+
+	return &Kms{client: client, prefix: prefix}
+}
+
+func (k *Kms) Keys(ctx context.Context, appName string) ([]Key, error) {
+
+	client, err := k.api(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return describeKmsKey(ctx, client, kmsAliasName(k.prefix, appName))
+}
+
+// describeKmsKey resolves the alias to the KMS key it currently targets. The key id is used as the
+// version, so that pointing the alias at a new key is picked up as a rotation.
+func describeKmsKey(ctx context.Context, client KmsAPI, alias string) ([]Key, error) {
+
+	output, err := client.DescribeKey(ctx, &kms.DescribeKeyInput{
+		KeyId: aws.String(alias),
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := output.KeyMetadata
+
+	if metadata.KeyUsage != types.KeyUsageTypeSignVerify || !slices.Contains(metadata.SigningAlgorithms, types.SigningAlgorithmSpecRsassaPkcs1V15Sha256) {
+		return nil, errors.New(fmt.Sprintf("KMS key %s does not support %s signing", alias, types.SigningAlgorithmSpecRsassaPkcs1V15Sha256))
+	}
+
+	keyId := aws.ToString(metadata.KeyId)
+
+	return []Key{{
+		Id:      keyId,
+		Version: keyId,
+		Signer:  &kmsSigner{client: client, keyId: keyId},
+	}}, nil
+}
+
+func kmsAliasName(prefix string, name string) string {
+
+	return fmt.Sprintf("alias%s/%s", strings.TrimSuffix(prefix, "/"), name)
+}
+
+// api returns the client, creating it from the default AWS configuration on first use. Keys of several apps
+// are read at the same time, so the client is created holding the lock.
+func (k *Kms) api(ctx context.Context) (KmsAPI, error) {
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.client == nil {
+		cfg, err := config.LoadDefaultConfig(ctx)
+		if err != nil {
+			return nil, err
+		}
+		k.client = kms.NewFromConfig(cfg)
+	}
+
+	return k.client, nil
+}
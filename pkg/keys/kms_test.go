@@ -0,0 +1,98 @@
+package keys
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/golang-jwt/jwt/v4"
+	"testing"
+)
+
+// fakeKms signs with a local RSA key the same way KMS signs a digest with RSASSA_PKCS1_V1_5_SHA_256.
+type fakeKms struct {
+	key      *rsa.PrivateKey
+	keyUsage types.KeyUsageType
+}
+
+func (f *fakeKms) DescribeKey(ctx context.Context, params *kms.DescribeKeyInput, optFns ...func(*kms.Options)) (*kms.DescribeKeyOutput, error) {
+
+	return &kms.DescribeKeyOutput{
+		KeyMetadata: &types.KeyMetadata{
+			KeyId:             aws.String("1234abcd-12ab-34cd-56ef-1234567890ab"),
+			KeyUsage:          f.keyUsage,
+			SigningAlgorithms: []types.SigningAlgorithmSpec{types.SigningAlgorithmSpecRsassaPkcs1V15Sha256},
+		},
+	}, nil
+}
+
+func (f *fakeKms) Sign(ctx context.Context, params *kms.SignInput, optFns ...func(*kms.Options)) (*kms.SignOutput, error) {
+
+	signature, err := rsa.SignPKCS1v15(rand.Reader, f.key, crypto.SHA256, params.Message)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &kms.SignOutput{Signature: signature, KeyId: params.KeyId}, nil
+}
+
+func Test_kmsSigner(t *testing.T) {
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := describeKmsKey(context.TODO(), &fakeKms{key: key, keyUsage: types.KeyUsageTypeSignVerify}, "alias/default")
+
+	if err != nil {
+		t.Fatalf("describeKmsKey() error = %v", err)
+	}
+
+	signed, err := keys[0].Signer.Sign(&jwt.RegisteredClaims{Issuer: "1234"})
+
+	if err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+
+	claims := &jwt.RegisteredClaims{}
+	_, err = jwt.ParseWithClaims(signed, claims, func(token *jwt.Token) (interface{}, error) {
+		return &key.PublicKey, nil
+	})
+
+	if err != nil {
+		t.Fatalf("Sign() produced invalid JWT: %v", err)
+	}
+
+	if claims.Issuer != "1234" {
+		t.Errorf("Sign() got issuer = %v, want %v", claims.Issuer, "1234")
+	}
+
+	_, err = describeKmsKey(context.TODO(), &fakeKms{key: key, keyUsage: types.KeyUsageTypeEncryptDecrypt}, "alias/default")
+
+	if err == nil {
+		t.Error("describeKmsKey() did not return error for encryption key")
+	}
+}
+
+func Test_kmsAliasName(t *testing.T) {
+	tests := []struct {
+		prefix string
+		want   string
+	}{
+		{prefix: "/", want: "alias/default"},
+		{prefix: "/catnekaise/github-apps", want: "alias/catnekaise/github-apps/default"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.prefix, func(t *testing.T) {
+			if got := kmsAliasName(tt.prefix, "default"); got != tt.want {
+				t.Errorf("kmsAliasName() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
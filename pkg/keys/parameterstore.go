@@ -0,0 +1,118 @@
+package keys
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"path"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+type ParameterStoreAPI interface {
+	GetParameter(ctx context.Context, params *ssm.GetParameterInput, optFns ...func(*ssm.Options)) (*ssm.GetParameterOutput, error)
+	GetParametersByPath(ctx context.Context, params *ssm.GetParametersByPathInput, optFns ...func(*ssm.Options)) (*ssm.GetParametersByPathOutput, error)
+}
+
+// ParameterStore reads every parameter below <prefix>/<appName>/, named by key id and ordered by last
+// modification, and falls back to the single parameter at <prefix>/<appName>.
+type ParameterStore struct {
+	mu     sync.Mutex
+	client ParameterStoreAPI
+	prefix string
+}
+
+// NewParameterStore returns a ParameterStore using client, or a client created from the default AWS
+// configuration on first use when client is nil.
+func NewParameterStore(client ParameterStoreAPI, prefix string) *ParameterStore {
+
+	return &ParameterStore{client: client, prefix: prefix}
+}
+
+func (p *ParameterStore) Keys(ctx context.Context, appName string) ([]Key, error) {
+
+	client, err := p.api(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	parameterName := fmt.Sprintf("%s/%s", p.prefix, appName)
+
+	var parameters []types.Parameter
+
+	paginator := ssm.NewGetParametersByPathPaginator(client, &ssm.GetParametersByPathInput{
+		Path:           aws.String(parameterName),
+		WithDecryption: aws.Bool(true),
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+
+		if err != nil {
+			return nil, err
+		}
+
+		parameters = append(parameters, page.Parameters...)
+	}
+
+	if len(parameters) > 0 {
+		return parameterStoreKeys(parameters), nil
+	}
+
+	parameter, err := client.GetParameter(ctx, &ssm.GetParameterInput{
+		Name:           aws.String(parameterName),
+		WithDecryption: aws.Bool(true),
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return []Key{{
+		Id:      DefaultKeyId,
+		PEM:     []byte(aws.ToString(parameter.Parameter.Value)),
+		Version: strconv.FormatInt(parameter.Parameter.Version, 10),
+	}}, nil
+}
+
+func parameterStoreKeys(parameters []types.Parameter) []Key {
+
+	sort.SliceStable(parameters, func(i, j int) bool {
+		return aws.ToTime(parameters[i].LastModifiedDate).After(aws.ToTime(parameters[j].LastModifiedDate))
+	})
+
+	keys := make([]Key, 0, len(parameters))
+
+	for _, parameter := range parameters {
+		keys = append(keys, Key{
+			Id:      path.Base(aws.ToString(parameter.Name)),
+			PEM:     []byte(aws.ToString(parameter.Value)),
+			Version: strconv.FormatInt(parameter.Version, 10),
+		})
+	}
+
+	return keys
+}
+
+// api returns the client, creating it from the default AWS configuration on first use. Keys of several apps
+// are read at the same time, so the client is created holding the lock.
+func (p *ParameterStore) api(ctx context.Context) (ParameterStoreAPI, error) {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.client == nil {
+		cfg, err := config.LoadDefaultConfig(ctx)
+		if err != nil {
+			return nil, err
+		}
+		p.client = ssm.NewFromConfig(cfg)
+	}
+
+	return p.client, nil
+}
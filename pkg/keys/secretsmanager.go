@@ -0,0 +1,107 @@
+package keys
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type SecretsManagerAPI interface {
+	GetSecretValue(ctx context.Context, params *secretsmanager.GetSecretValueInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error)
+}
+
+// SecretsManager reads the secret at <prefix>/<appName>. The secret is either a single PEM encoded key or a
+// JSON array of {"id": "...", "privateKey": "..."} objects ordered newest first.
+type SecretsManager struct {
+	mu     sync.Mutex
+	client SecretsManagerAPI
+	prefix string
+}
+
+type secretsManagerKey struct {
+	Id         string `json:"id"`
+	PrivateKey string `json:"privateKey"`
+}
+
+// NewSecretsManager returns a SecretsManager using client, or a client created from the default AWS
+// configuration on first use when client is nil.
+func NewSecretsManager(client SecretsManagerAPI, prefix string) *SecretsManager {
+
+	return &SecretsManager{client: client, prefix: prefix}
+}
+
+func (s *SecretsManager) Keys(ctx context.Context, appName string) ([]Key, error) {
+
+	client, err := s.api(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := client.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(fmt.Sprintf("%s/%s", s.prefix, appName)),
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return parseSecretsManagerKeys(aws.ToString(secret.SecretString), aws.ToString(secret.VersionId))
+}
+
+func parseSecretsManagerKeys(value string, version string) ([]Key, error) {
+
+	if !strings.HasPrefix(strings.TrimSpace(value), "[") {
+		return []Key{{Id: DefaultKeyId, PEM: []byte(value), Version: version}}, nil
+	}
+
+	var entries []secretsManagerKey
+
+	if err := json.Unmarshal([]byte(value), &entries); err != nil {
+		return nil, errors.New(fmt.Sprintf("secret is not a valid JSON array of private keys: %s", err.Error()))
+	}
+
+	if len(entries) == 0 {
+		return nil, errors.New("secret does not contain any private keys")
+	}
+
+	keys := make([]Key, 0, len(entries))
+
+	for i, entry := range entries {
+
+		id := entry.Id
+
+		if id == "" {
+			id = strconv.Itoa(i)
+		}
+
+		keys = append(keys, Key{Id: id, PEM: []byte(entry.PrivateKey), Version: version})
+	}
+
+	return keys, nil
+}
+
+// api returns the client, creating it from the default AWS configuration on first use. Keys of several apps
+// are read at the same time, so the client is created holding the lock.
+func (s *SecretsManager) api(ctx context.Context) (SecretsManagerAPI, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.client == nil {
+		cfg, err := config.LoadDefaultConfig(ctx)
+		if err != nil {
+			return nil, err
+		}
+		s.client = secretsmanager.NewFromConfig(cfg)
+	}
+
+	return s.client, nil
+}
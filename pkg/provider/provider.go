@@ -0,0 +1,140 @@
+// Package provider embeds the token provider in other programs, such as a Lambda function with its own
+// main, without the package level state and environment variables of the bundled function.
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/catnekaise/ghrawel-tokenprovider-lambda-go/internal"
+	"github.com/catnekaise/ghrawel-tokenprovider-lambda-go/pkg/api"
+	"github.com/catnekaise/ghrawel-tokenprovider-lambda-go/pkg/keys"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// DefaultPrivateKeyCacheTtl is how long private keys are kept before they are read again.
+const DefaultPrivateKeyCacheTtl = 5 * time.Minute
+
+// Provider creates and revokes installation tokens. It caches private keys and installations, so a
+// program should create one Provider and use it for all requests.
+type Provider struct {
+	service *internal.Service
+}
+
+type Option func(*options)
+
+type options struct {
+	internal.Options
+}
+
+// WithKeyProvider reads the private keys of the apps from p.
+func WithKeyProvider(p keys.KeyProvider) Option {
+	return func(o *options) {
+		o.KeyProvider = p
+	}
+}
+
+// WithSecretsStorage reads the private keys from a storage registered with keys.Register, such as
+// api.SecretsStorageParameterStore. It is ignored when a key provider is set.
+func WithSecretsStorage(storage string, prefix string) Option {
+	return func(o *options) {
+		o.SecretsStorage = storage
+		o.SecretsPrefix = prefix
+	}
+}
+
+// WithAllowedBaseUrls sets the base URLs, besides the one of WithBaseUrl, apps may set as their own base URL.
+func WithAllowedBaseUrls(baseUrls ...string) Option {
+
+	return func(o *options) {
+		o.AllowedBaseUrls = baseUrls
+	}
+}
+
+// WithBaseUrl sets the API URL of the GitHub Enterprise Server of apps without a base URL of their own.
+func WithBaseUrl(baseUrl string) Option {
+	return func(o *options) {
+		o.BaseUrl = baseUrl
+	}
+}
+
+// WithTransport sends the requests to GitHub through rt instead of the tuned transport with retries.
+func WithTransport(rt http.RoundTripper) Option {
+	return func(o *options) {
+		o.Transport = rt
+	}
+}
+
+// WithLogger logs to l instead of slog.Default. The fields of the request are added to every record.
+func WithLogger(l *slog.Logger) Option {
+	return func(o *options) {
+		o.Logger = l
+	}
+}
+
+// WithClock sets the clock the caches expire by.
+func WithClock(now func() time.Time) Option {
+	return func(o *options) {
+		o.Now = now
+	}
+}
+
+// WithPrivateKeyCacheTtl sets how long private keys are kept before they are read again, 0 reads them on
+// every request.
+func WithPrivateKeyCacheTtl(ttl time.Duration) Option {
+	return func(o *options) {
+		o.PrivateKeyCacheTtl = ttl
+	}
+}
+
+// WithProviders sets the providers served by Invoke to payloads of a Function URL, an HTTP API and direct
+// invocations.
+func WithProviders(providers ...api.ProviderConfig) Option {
+	return func(o *options) {
+		o.Providers = map[string]api.ProviderConfig{}
+
+		for _, provider := range providers {
+			o.Providers[provider.ProviderName] = provider
+		}
+	}
+}
+
+// WithPermissionPolicy rejects requests for permissions the policy does not allow before GitHub is called.
+func WithPermissionPolicy(policy api.PermissionPolicy) Option {
+	return func(o *options) {
+		o.PermissionPolicy = &policy
+	}
+}
+
+// New creates a Provider. Without WithKeyProvider or WithSecretsStorage it fails, as there is nowhere to
+// read private keys from.
+func New(ctx context.Context, opts ...Option) (*Provider, error) {
+
+	o := &options{internal.Options{PrivateKeyCacheTtl: DefaultPrivateKeyCacheTtl}}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	service, err := internal.NewService(ctx, o.Options)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Provider{service: service}, nil
+}
+
+// Handle handles a request of the ghrawel REST API, where the mapping template supplies the TokenContext. The
+// result is an *api.TokenResponse, or an *api.RevokeResponse for EndpointTypeRevoke.
+func (p *Provider) Handle(ctx context.Context, req api.Input) (any, error) {
+
+	return p.service.Handle(ctx, req)
+}
+
+// Invoke handles every payload the bundled function accepts and can be passed to lambda.Start as is.
+func (p *Provider) Invoke(ctx context.Context, payload json.RawMessage) (any, error) {
+
+	return p.service.Invoke(ctx, payload)
+}
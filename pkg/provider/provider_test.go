@@ -0,0 +1,81 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"github.com/catnekaise/ghrawel-tokenprovider-lambda-go/pkg/api"
+	"github.com/catnekaise/ghrawel-tokenprovider-lambda-go/pkg/fakegithub"
+	"github.com/catnekaise/ghrawel-tokenprovider-lambda-go/pkg/keys"
+	"github.com/google/go-github/v60/github"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+type staticKeys []keys.Key
+
+func (k staticKeys) Keys(ctx context.Context, appName string) ([]keys.Key, error) {
+	return k, nil
+}
+
+func TestProvider_Handle(t *testing.T) {
+
+	server := fakegithub.New(t, 1234, fakegithub.Installation{
+		Id:           1,
+		Owner:        "catnekaise",
+		Permissions:  map[string]string{"contents": "read"},
+		Repositories: []fakegithub.Repository{{Id: 10, Name: "example-repo"}},
+	})
+
+	logs := new(bytes.Buffer)
+
+	p, err := New(context.TODO(),
+		WithKeyProvider(staticKeys{{Id: keys.DefaultKeyId, Version: "1", PEM: server.PrivateKey()}}),
+		WithBaseUrl(server.URL),
+		WithLogger(slog.New(slog.NewJSONHandler(logs, nil))),
+		WithClock(time.Now),
+	)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := api.Input{
+		TokenRequest: api.TokenRequest{Owner: "catnekaise", Repo: github.String("example-repo")},
+		TokenContext: api.TokenContext{
+			ProviderName: "example",
+			Permissions:  api.Permissions{Contents: github.String("read")},
+			App:          api.App{Id: 1234, Name: "default"},
+			Endpoint:     api.Endpoint{Type: api.EndpointTypeDefault},
+			TargetRule:   api.TargetRule{RepositorySelectionMode: api.RepositorySelectionModeAtLeastOne},
+		},
+	}
+
+	got, err := p.Handle(context.TODO(), req)
+
+	if err != nil {
+		t.Fatalf("Handle() error = %v", err)
+	}
+
+	token, ok := got.(*api.TokenResponse)
+
+	if !ok || token.Token != server.Tokens()[0].Token {
+		t.Errorf("Handle() got = %+v, want token of fake", got)
+	}
+
+	if !strings.Contains(logs.String(), `"msg":"TokenCreated"`) || !strings.Contains(logs.String(), `"tokenProviderName":"example"`) {
+		t.Errorf("Handle() did not log to logger, got %s", logs)
+	}
+}
+
+func TestNew(t *testing.T) {
+
+	if _, err := New(context.TODO()); err == nil {
+		t.Error("New() without private keys did not return error")
+	}
+
+	if _, err := New(context.TODO(), WithSecretsStorage(api.SecretsStorageParameterStore, "#/")); err == nil {
+		t.Error("New() with invalid prefix did not return error")
+	}
+}